@@ -0,0 +1,420 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Reasons a user can give when hiding a match.
+const (
+	hideReasonWrongMovie  = "wrong_movie"
+	hideReasonBadQuality  = "bad_quality"
+	hideReasonFake        = "fake"
+	hideReasonBadUploader = "bad_uploader"
+)
+
+var hideReasons = []string{hideReasonWrongMovie, hideReasonBadQuality, hideReasonFake, hideReasonBadUploader}
+
+// Kinds of block rules. Sites don't expose uploaders, so uploader problems are
+// tracked through the release group that names them.
+const (
+	blockRuleReleaseGroup = "release_group"
+	blockRuleSite         = "site"
+	blockRuleToken        = "token"
+)
+
+var blockRuleKinds = []string{blockRuleReleaseGroup, blockRuleSite, blockRuleToken}
+
+const (
+	blockRuleSuggested = "suggested"
+	blockRuleAccepted  = "accepted"
+	blockRuleDismissed = "dismissed"
+)
+
+var blockRuleStatuses = []string{blockRuleSuggested, blockRuleAccepted, blockRuleDismissed}
+
+// BlockRule rejects matching candidates for every item once accepted.
+type BlockRule struct {
+	ID      int64  `json:"id"`
+	Kind    string `json:"kind"`
+	Value   string `json:"value"`
+	Status  string `json:"status"`
+	Reason  string `json:"reason,omitempty"` // most common hide reason behind a suggestion
+	Hits    int    `json:"hits"`             // hidden matches that support the rule
+	Created string `json:"created"`
+	Updated string `json:"updated"`
+}
+
+// blockRules is the set of accepted rules the worker applies.
+type blockRules struct {
+	groups map[string]bool
+	sites  map[string]bool
+	tokens []string
+}
+
+func loadBlockRules() (*blockRules, error) {
+	rows, err := db.Query(`SELECT kind, value FROM block_rules WHERE status = $1`, blockRuleAccepted)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	rules := &blockRules{groups: map[string]bool{}, sites: map[string]bool{}}
+	for rows.Next() {
+		var kind, value string
+		if err := rows.Scan(&kind, &value); err != nil {
+			return nil, err
+		}
+		switch kind {
+		case blockRuleReleaseGroup:
+			rules.groups[strings.ToLower(value)] = true
+		case blockRuleSite:
+			rules.sites[strings.ToLower(value)] = true
+		case blockRuleToken:
+			rules.tokens = append(rules.tokens, value)
+		}
+	}
+	return rules, rows.Err()
+}
+
+// Reject returns the first accepted rule the candidate hits, or "".
+func (b *blockRules) Reject(site, title string, info ReleaseInfo) string {
+	if b == nil {
+		return ""
+	}
+	if b.sites[strings.ToLower(site)] {
+		return fmt.Sprintf("site %q", site)
+	}
+	if info.Group != "" && b.groups[strings.ToLower(info.Group)] {
+		return fmt.Sprintf("release_group %q", info.Group)
+	}
+	if len(b.tokens) > 0 {
+		tokens := titleTokens(title)
+		lower := strings.ToLower(title)
+		for _, t := range b.tokens {
+			if tokenPresent(t, tokens, lower) {
+				return fmt.Sprintf("token %q", t)
+			}
+		}
+	}
+	return ""
+}
+
+// -------------------- Suggestions --------------------
+
+// hiddenMatch is a match the user hid with a reason.
+type hiddenMatch struct {
+	itemText string
+	site     string
+	title    string
+	reason   string
+}
+
+type ruleTally struct {
+	hits    int
+	reasons map[string]int
+}
+
+func (t *ruleTally) add(reason string) {
+	t.hits++
+	t.reasons[reason]++
+}
+
+func (t *ruleTally) topReason() string {
+	best, n := "", 0
+	for r, c := range t.reasons {
+		if c > n || (c == n && r < best) {
+			best, n = r, c
+		}
+	}
+	return best
+}
+
+// suggestBlockRules looks for patterns repeated across matches hidden with a
+// reason and stores them as suggested rules. A pattern needs
+// BLOCK_RULE_MIN_HIDES (default 3) hides; tokens must also be rare among
+// visible matches. "wrong_movie" hides are about matching rather than the
+// release itself, so they never produce rules.
+func suggestBlockRules() error {
+	minHides := getenvInt("BLOCK_RULE_MIN_HIDES", 3)
+	if minHides < 1 {
+		minHides = 1
+	}
+
+	rows, err := db.Query(`
+        SELECT i.text, m.source_site, COALESCE(m.torrent_text, m.matched_text, ''), m.hide_reason
+        FROM matches m
+        JOIN items i ON i.id = m.item_id
+        WHERE m.soft_delete = TRUE AND m.hide_reason IS NOT NULL AND m.hide_reason <> $1
+    `, hideReasonWrongMovie)
+	if err != nil {
+		return err
+	}
+	var hidden []hiddenMatch
+	for rows.Next() {
+		var h hiddenMatch
+		if err := rows.Scan(&h.itemText, &h.site, &h.title, &h.reason); err != nil {
+			rows.Close()
+			return err
+		}
+		hidden = append(hidden, h)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	tallies := map[[2]string]*ruleTally{}
+	tally := func(kind, value, reason string) {
+		key := [2]string{kind, value}
+		if tallies[key] == nil {
+			tallies[key] = &ruleTally{reasons: map[string]int{}}
+		}
+		tallies[key].add(reason)
+	}
+	for _, h := range hidden {
+		info := parseReleaseName(h.title)
+		if info.Group != "" {
+			tally(blockRuleReleaseGroup, info.Group, h.reason)
+		}
+		if h.reason == hideReasonFake || h.reason == hideReasonBadUploader {
+			tally(blockRuleSite, h.site, h.reason)
+		}
+		if h.reason == hideReasonFake || h.reason == hideReasonBadQuality {
+			for _, tok := range suggestionTokens(h.itemText, h.title, info) {
+				tally(blockRuleToken, tok, h.reason)
+			}
+		}
+	}
+
+	visibleTokens, err := visibleTokenCounts()
+	if err != nil {
+		return err
+	}
+
+	for key, t := range tallies {
+		kind, value := key[0], key[1]
+		if t.hits < minHides {
+			continue
+		}
+		// A token that also shows up in plenty of wanted matches is just noise
+		if kind == blockRuleToken && visibleTokens[value]*4 > t.hits {
+			continue
+		}
+		if _, err := db.Exec(`
+            INSERT INTO block_rules(kind, value, status, reason, hits)
+            VALUES ($1, $2, $3, $4, $5)
+            ON CONFLICT (kind, value) DO UPDATE
+                SET hits = EXCLUDED.hits, reason = EXCLUDED.reason, updated_at = CURRENT_TIMESTAMP
+                WHERE block_rules.status = $3
+        `, kind, value, blockRuleSuggested, t.topReason(), t.hits); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// suggestionTokens returns the words of a hidden title that could explain the
+// hide: not part of the item's text, not the year, group or episode code, and
+// long enough to mean something.
+func suggestionTokens(itemText, title string, info ReleaseInfo) []string {
+	skip := map[string]bool{}
+	for _, w := range strings.Fields(normalize(itemText)) {
+		skip[w] = true
+	}
+	for _, w := range strings.Fields(normalize(info.Title + " " + info.Group)) {
+		skip[w] = true
+	}
+	var out []string
+	seen := map[string]bool{}
+	for _, w := range strings.Fields(normalize(title)) {
+		if len(w) < 3 || skip[w] || seen[w] || extractYear(w) != "" || isDigits(w) {
+			continue
+		}
+		seen[w] = true
+		out = append(out, w)
+	}
+	return out
+}
+
+func isDigits(s string) bool {
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return s != ""
+}
+
+// visibleTokenCounts counts, per normalized word, the visible matches whose
+// title contains it.
+func visibleTokenCounts() (map[string]int, error) {
+	rows, err := db.Query(`SELECT COALESCE(torrent_text, matched_text, '') FROM matches WHERE soft_delete = FALSE`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	counts := map[string]int{}
+	for rows.Next() {
+		var title string
+		if err := rows.Scan(&title); err != nil {
+			return nil, err
+		}
+		seen := map[string]bool{}
+		for _, w := range strings.Fields(normalize(title)) {
+			if !seen[w] {
+				seen[w] = true
+				counts[w]++
+			}
+		}
+	}
+	return counts, rows.Err()
+}
+
+// -------------------- HTTP --------------------
+
+const blockRuleColumns = `id, kind, value, status, COALESCE(reason, ''), hits, created_at, updated_at`
+
+func scanBlockRule(row rowScanner) (BlockRule, error) {
+	var b BlockRule
+	err := row.Scan(&b.ID, &b.Kind, &b.Value, &b.Status, &b.Reason, &b.Hits, &b.Created, &b.Updated)
+	return b, err
+}
+
+// blockRulesHandler serves GET /api/block-rules (?status=) and POST, which
+// adds an accepted rule by hand (form fields kind and value).
+func blockRulesHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		status := r.URL.Query().Get("status")
+		if status != "" && !containsString(blockRuleStatuses, status) {
+			http.Error(w, "invalid status (use suggested, accepted or dismissed)", http.StatusBadRequest)
+			return
+		}
+		rows, err := db.Query(`
+            SELECT `+blockRuleColumns+`
+            FROM block_rules
+            WHERE ($1 = '' OR status = $1)
+            ORDER BY status = 'suggested' DESC, hits DESC, id ASC
+        `, status)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		defer rows.Close()
+
+		out := make([]BlockRule, 0, 32)
+		for rows.Next() {
+			b, err := scanBlockRule(rows)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			out = append(out, b)
+		}
+		writeJSON(w, out)
+
+	case http.MethodPost:
+		if err := r.ParseForm(); err != nil {
+			http.Error(w, "invalid form", http.StatusBadRequest)
+			return
+		}
+		kind := strings.TrimSpace(r.FormValue("kind"))
+		value := strings.TrimSpace(r.FormValue("value"))
+		if !containsString(blockRuleKinds, kind) {
+			http.Error(w, "invalid kind (use release_group, site or token)", http.StatusBadRequest)
+			return
+		}
+		if value == "" {
+			http.Error(w, "value required", http.StatusBadRequest)
+			return
+		}
+		var id int64
+		err := db.QueryRow(`
+            INSERT INTO block_rules(kind, value, status)
+            VALUES ($1, $2, $3)
+            ON CONFLICT (kind, value) DO UPDATE SET status = EXCLUDED.status, updated_at = CURRENT_TIMESTAMP
+            RETURNING id
+        `, kind, value, blockRuleAccepted).Scan(&id)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+		writeJSON(w, map[string]any{"id": id})
+
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// blockRuleHandler serves PUT /api/block-rules/{id} (form field status, to
+// accept or dismiss a suggestion) and DELETE.
+func blockRuleHandler(w http.ResponseWriter, r *http.Request) {
+	idStr := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/block-rules/"), "/")
+	id, err := strconv.ParseInt(idStr, 10, 64)
+	if err != nil || id <= 0 {
+		http.Error(w, "invalid id", http.StatusBadRequest)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodPut:
+		if err := r.ParseForm(); err != nil {
+			http.Error(w, "invalid form", http.StatusBadRequest)
+			return
+		}
+		status := strings.TrimSpace(r.FormValue("status"))
+		if !containsString(blockRuleStatuses, status) {
+			http.Error(w, "invalid status (use suggested, accepted or dismissed)", http.StatusBadRequest)
+			return
+		}
+		res, err := db.Exec(`UPDATE block_rules SET status = $1, updated_at = CURRENT_TIMESTAMP WHERE id = $2`, status, id)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			http.Error(w, "not found", http.StatusNotFound)
+			return
+		}
+		writeJSON(w, map[string]any{"ok": true})
+
+	case http.MethodDelete:
+		if _, err := db.Exec(`DELETE FROM block_rules WHERE id = $1`, id); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, map[string]any{"ok": true})
+
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// parseHideReason validates the optional reason given when hiding a match.
+func parseHideReason(v string) (sql.NullString, error) {
+	v = strings.TrimSpace(v)
+	if v == "" {
+		return sql.NullString{}, nil
+	}
+	if !containsString(hideReasons, v) {
+		return sql.NullString{}, fmt.Errorf("invalid reason (use %s)", strings.Join(hideReasons, ", "))
+	}
+	return sql.NullString{String: v, Valid: true}, nil
+}
+
+// refreshBlockRuleSuggestions recomputes suggestions in the background after
+// a match is hidden with a reason.
+func refreshBlockRuleSuggestions() {
+	go func() {
+		if err := suggestBlockRules(); err != nil {
+			log.Printf("Failed to update block rule suggestions: %v\n", err)
+		}
+	}()
+}
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Final decisions recorded for a candidate.
+const (
+	decisionMatched    = "matched"     // inserted as a visible match
+	decisionRejected   = "rejected"    // failed a stage
+	decisionNotKept    = "not_kept"    // matched, but ranked out by the item's limit or made redundant
+	decisionDuplicate  = "duplicate"   // matched, but already stored for this item
+	decisionAutoHidden = "auto_hidden" // matched, but stored hidden (zero seeds)
+)
+
+var candidateDecisions = []string{decisionMatched, decisionRejected, decisionNotKept, decisionDuplicate, decisionAutoHidden}
+
+// candidateStage is one step of the decision trace.
+type candidateStage struct {
+	Stage  string `json:"stage"`
+	Passed bool   `json:"passed"`
+	Detail string `json:"detail,omitempty"`
+}
+
+// candidateTrace collects the verdict of every stage a search result goes
+// through in a worker run and is saved to the candidates table once the result
+// has a final decision.
+type candidateTrace struct {
+	runID  int64
+	itemID int64
+	site   string
+	query  string
+	title  string
+	url    string
+	stages []candidateStage
+	score  *float64
+	quiet  bool // offline re-evaluation: no logging, never saved
+}
+
+func newCandidateTrace(runID, itemID int64, site, query string, r SearchResult) *candidateTrace {
+	return &candidateTrace{runID: runID, itemID: itemID, site: site, query: query, title: r.Title, url: r.URL}
+}
+
+// stage records a verdict without deciding the candidate's fate.
+func (t *candidateTrace) stage(stage string, passed bool, detail string) {
+	if t == nil {
+		return
+	}
+	t.stages = append(t.stages, candidateStage{Stage: stage, Passed: passed, Detail: detail})
+}
+
+// logf logs a matching step unless the trace is quiet.
+func (t *candidateTrace) logf(format string, args ...any) {
+	if t != nil && t.quiet {
+		return
+	}
+	log.Printf(format, args...)
+}
+
+// reject records a failed stage and saves the candidate as rejected.
+func (t *candidateTrace) reject(stage, detail string) {
+	t.stage(stage, false, detail)
+	t.finish(decisionRejected, stage, 0)
+}
+
+// finish saves the candidate with its final decision.
+func (t *candidateTrace) finish(decision, reason string, matchID int64) {
+	if t == nil || t.quiet {
+		return
+	}
+	stagesJSON, _ := json.Marshal(t.stages)
+	var runID, mID sql.NullInt64
+	if t.runID > 0 {
+		runID = sql.NullInt64{Int64: t.runID, Valid: true}
+	}
+	if matchID > 0 {
+		mID = sql.NullInt64{Int64: matchID, Valid: true}
+	}
+	if _, err := db.Exec(`
+        INSERT INTO candidates(run_id, item_id, site, query, title, url, stages, score, decision, reason, match_id)
+        VALUES ($1, $2, $3, $4, $5, $6, $7::jsonb, $8, $9, NULLIF($10, ''), $11)
+    `, runID, t.itemID, t.site, t.query, t.title, t.url, string(stagesJSON), t.score, decision, reason, mID); err != nil {
+		log.Printf("Failed to record candidate %q: %v\n", t.title, err)
+	}
+}
+
+// nextRunID hands out the id of a worker run (see runs.go). Candidates, jobs,
+// logs and matches of one run share it.
+func nextRunID() (int64, error) {
+	var id int64
+	err := db.QueryRow(`SELECT nextval('worker_run_seq')`).Scan(&id)
+	return id, err
+}
+
+// pruneCandidates deletes candidates older than CANDIDATE_RETENTION_DAYS
+// (default 14; 0 keeps them forever).
+func pruneCandidates() {
+	days := getenvInt("CANDIDATE_RETENTION_DAYS", 14)
+	if days <= 0 {
+		return
+	}
+	res, err := db.Exec(`DELETE FROM candidates WHERE created_at < CURRENT_TIMESTAMP - make_interval(days => $1)`, days)
+	if err != nil {
+		log.Printf("Failed to prune candidates: %v\n", err)
+		return
+	}
+	if n, _ := res.RowsAffected(); n > 0 {
+		log.Printf("Pruned %d candidates older than %d days\n", n, days)
+	}
+}
+
+// Candidate is a row of GET /api/items/{id}/candidates.
+type Candidate struct {
+	ID       int64            `json:"id"`
+	RunID    *int64           `json:"run_id,omitempty"`
+	ItemID   int64            `json:"item_id"`
+	Site     string           `json:"site"`
+	Query    string           `json:"query,omitempty"`
+	Title    string           `json:"title"`
+	URL      string           `json:"url"`
+	Stages   []candidateStage `json:"stages"`
+	Score    *float64         `json:"score,omitempty"`
+	Decision string           `json:"decision"`
+	Reason   string           `json:"reason,omitempty"`
+	MatchID  *int64           `json:"match_id,omitempty"`
+	Created  string           `json:"created"`
+}
+
+// itemCandidatesHandler lists an item's evaluated candidates, newest first.
+// Optional filters: ?decision=, ?run_id=, ?limit= (default 200, max 1000).
+func itemCandidatesHandler(w http.ResponseWriter, r *http.Request, itemID int64) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	q := r.URL.Query()
+	decision := strings.TrimSpace(q.Get("decision"))
+	if decision != "" && !containsString(candidateDecisions, decision) {
+		http.Error(w, fmt.Sprintf("invalid decision (use one of %s)", strings.Join(candidateDecisions, ", ")), http.StatusBadRequest)
+		return
+	}
+	runID, err := parseOptionalID(q.Get("run_id"))
+	if err != nil {
+		http.Error(w, "invalid run_id", http.StatusBadRequest)
+		return
+	}
+	limit := 200
+	if v := q.Get("limit"); v != "" {
+		if limit, err = strconv.Atoi(v); err != nil || limit <= 0 {
+			http.Error(w, "invalid limit", http.StatusBadRequest)
+			return
+		}
+		if limit > 1000 {
+			limit = 1000
+		}
+	}
+
+	rows, err := db.Query(`
+        SELECT id, run_id, item_id, site, COALESCE(query, ''), title, url, stages, score, decision,
+               COALESCE(reason, ''), match_id, created_at
+        FROM candidates
+        WHERE item_id = $1
+          AND ($2 = '' OR decision = $2)
+          AND ($3::BIGINT IS NULL OR run_id = $3)
+        ORDER BY created_at DESC, id DESC
+        LIMIT $4
+    `, itemID, decision, runID, limit)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	out := make([]Candidate, 0, limit)
+	for rows.Next() {
+		var c Candidate
+		var runID, matchID sql.NullInt64
+		var score sql.NullFloat64
+		var stages []byte
+		if err := rows.Scan(&c.ID, &runID, &c.ItemID, &c.Site, &c.Query, &c.Title, &c.URL, &stages, &score, &c.Decision,
+			&c.Reason, &matchID, &c.Created); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if runID.Valid {
+			c.RunID = &runID.Int64
+		}
+		if matchID.Valid {
+			c.MatchID = &matchID.Int64
+		}
+		if score.Valid {
+			c.Score = &score.Float64
+		}
+		_ = json.Unmarshal(stages, &c.Stages)
+		out = append(out, c)
+	}
+	writeJSON(w, out)
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,362 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"database/sql"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/lib/pq"
+)
+
+// IMDb title types we import and how they map onto item types.
+var catalogTitleTypes = map[string]string{
+	"movie":        itemTypeMovie,
+	"tvMovie":      itemTypeMovie,
+	"video":        itemTypeMovie,
+	"tvSeries":     itemTypeSeries,
+	"tvMiniSeries": itemTypeSeries,
+}
+
+// CatalogTitle is a title from the local catalog.
+type CatalogTitle struct {
+	ID             string   `json:"id"` // IMDb tconst, e.g. "tt0133093"
+	Type           string   `json:"type"`
+	ItemType       string   `json:"item_type"`
+	Title          string   `json:"title"`
+	OriginalTitle  string   `json:"original_title,omitempty"`
+	Year           int      `json:"year,omitempty"`
+	RuntimeMinutes int      `json:"runtime_minutes,omitempty"`
+	NumVotes       int      `json:"num_votes,omitempty"`
+	AltTitles      []string `json:"alt_titles,omitempty"`
+}
+
+// ItemText is the text a canonicalized item is stored and searched under.
+// Movies carry their year; series don't, since episode codes follow.
+func (c CatalogTitle) ItemText() string {
+	if c.ItemType == itemTypeSeries || c.Year == 0 {
+		return c.Title
+	}
+	return fmt.Sprintf("%s %d", c.Title, c.Year)
+}
+
+// catalogKey is the lookup key of a title: normalized, without a leading
+// article, so "the matrix" and "Matrix" resolve alike.
+func catalogKey(title string) string {
+	return leadingArticle.ReplaceAllString(normalize(title), "")
+}
+
+// -------------------- Import --------------------
+
+// runImportCatalogCommand implements `api import-catalog`, loading IMDb TSV
+// dumps (optionally gzipped) into the catalog. The catalog is replaced.
+func runImportCatalogCommand(args []string) error {
+	fs := flag.NewFlagSet("import-catalog", flag.ContinueOnError)
+	basicsPath := fs.String("basics", "", "path to title.basics.tsv(.gz) (required)")
+	akasPath := fs.String("akas", "", "path to title.akas.tsv(.gz)")
+	ratingsPath := fs.String("ratings", "", "path to title.ratings.tsv(.gz), used to rank ambiguous titles")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *basicsPath == "" {
+		return fmt.Errorf("-basics is required")
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`TRUNCATE catalog_akas, catalog_titles`); err != nil {
+		return err
+	}
+
+	ids := map[string]bool{}
+	n, err := copyTSV(tx, *basicsPath, "catalog_titles",
+		[]string{"id", "title_type", "primary_title", "original_title", "start_year", "runtime_minutes", "title_key"},
+		func(f []string) []any {
+			// tconst titleType primaryTitle originalTitle isAdult startYear endYear runtimeMinutes genres
+			if len(f) < 8 || catalogTitleTypes[f[1]] == "" || f[4] == "1" {
+				return nil
+			}
+			ids[f[0]] = true
+			return []any{f[0], f[1], f[2], tsvString(f[3]), tsvInt(f[5]), tsvInt(f[7]), catalogKey(f[2])}
+		})
+	if err != nil {
+		return fmt.Errorf("basics: %w", err)
+	}
+	log.Printf("Imported %d catalog titles\n", n)
+
+	if *akasPath != "" {
+		n, err := copyTSV(tx, *akasPath, "catalog_akas", []string{"title_id", "title", "region", "title_key"},
+			func(f []string) []any {
+				// titleId ordering title region language types attributes isOriginalTitle
+				if len(f) < 4 || !ids[f[0]] {
+					return nil
+				}
+				return []any{f[0], f[2], tsvString(f[3]), catalogKey(f[2])}
+			})
+		if err != nil {
+			return fmt.Errorf("akas: %w", err)
+		}
+		log.Printf("Imported %d alternate titles\n", n)
+	}
+
+	if *ratingsPath != "" {
+		if _, err := tx.Exec(`CREATE TEMP TABLE catalog_ratings_import (id TEXT, num_votes INTEGER) ON COMMIT DROP`); err != nil {
+			return err
+		}
+		n, err := copyTSV(tx, *ratingsPath, "catalog_ratings_import", []string{"id", "num_votes"},
+			func(f []string) []any {
+				// tconst averageRating numVotes
+				if len(f) < 3 || !ids[f[0]] {
+					return nil
+				}
+				return []any{f[0], tsvInt(f[2])}
+			})
+		if err != nil {
+			return fmt.Errorf("ratings: %w", err)
+		}
+		if _, err := tx.Exec(`
+            UPDATE catalog_titles t SET num_votes = r.num_votes
+            FROM catalog_ratings_import r WHERE r.id = t.id
+        `); err != nil {
+			return err
+		}
+		log.Printf("Imported %d ratings\n", n)
+	}
+
+	return tx.Commit()
+}
+
+// copyTSV streams a TSV file (header line first) into table with COPY. row
+// maps the fields of a line to column values, or nil to skip the line.
+func copyTSV(tx *sql.Tx, path, table string, columns []string, row func([]string) []any) (int, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	var r io.Reader = f
+	if strings.HasSuffix(path, ".gz") {
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			return 0, err
+		}
+		defer gz.Close()
+		r = gz
+	}
+
+	stmt, err := tx.Prepare(pq.CopyIn(table, columns...))
+	if err != nil {
+		return 0, err
+	}
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 1024*1024), 1024*1024)
+	n := 0
+	for first := true; scanner.Scan(); first = false {
+		if first {
+			continue // header
+		}
+		values := row(strings.Split(scanner.Text(), "\t"))
+		if values == nil {
+			continue
+		}
+		if _, err := stmt.Exec(values...); err != nil {
+			stmt.Close()
+			return n, err
+		}
+		n++
+	}
+	if err := scanner.Err(); err != nil {
+		stmt.Close()
+		return n, err
+	}
+	if _, err := stmt.Exec(); err != nil {
+		stmt.Close()
+		return n, err
+	}
+	return n, stmt.Close()
+}
+
+// tsvString and tsvInt read IMDb fields, where `\N` means null.
+func tsvString(v string) any {
+	if v == `\N` || v == "" {
+		return nil
+	}
+	return v
+}
+
+func tsvInt(v string) any {
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		return nil
+	}
+	return n
+}
+
+// -------------------- Lookup --------------------
+
+// searchCatalog finds catalog titles whose title or alternate title matches
+// text. A year in text narrows the results to titles from that year (±1, as
+// release dates vary by country). Results are ranked by popularity.
+func searchCatalog(text string, limit int) ([]CatalogTitle, error) {
+	year, _ := strconv.Atoi(extractYear(text))
+	key := catalogKey(strings.Trim(removeYear(text), " ()[]"))
+	if key == "" {
+		return nil, nil
+	}
+
+	rows, err := db.Query(`
+        SELECT t.id, t.title_type, t.primary_title, COALESCE(t.original_title, ''), COALESCE(t.start_year, 0),
+               COALESCE(t.runtime_minutes, 0), COALESCE(t.num_votes, 0)
+        FROM catalog_titles t
+        WHERE (t.title_key = $1 OR t.id IN (SELECT title_id FROM catalog_akas WHERE title_key = $1))
+          AND ($2 = 0 OR t.start_year BETWEEN $2 - 1 AND $2 + 1)
+        ORDER BY ($2 <> 0 AND t.start_year = $2) DESC, COALESCE(t.num_votes, 0) DESC, t.start_year DESC
+        LIMIT $3
+    `, key, year, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []CatalogTitle
+	for rows.Next() {
+		var c CatalogTitle
+		if err := rows.Scan(&c.ID, &c.Type, &c.Title, &c.OriginalTitle, &c.Year, &c.RuntimeMinutes, &c.NumVotes); err != nil {
+			return nil, err
+		}
+		c.ItemType = catalogTitleTypes[c.Type]
+		out = append(out, c)
+	}
+	return out, rows.Err()
+}
+
+func loadCatalogTitle(id string) (*CatalogTitle, error) {
+	var c CatalogTitle
+	err := db.QueryRow(`
+        SELECT id, title_type, primary_title, COALESCE(original_title, ''), COALESCE(start_year, 0),
+               COALESCE(runtime_minutes, 0), COALESCE(num_votes, 0)
+        FROM catalog_titles WHERE id = $1
+    `, id).Scan(&c.ID, &c.Type, &c.Title, &c.OriginalTitle, &c.Year, &c.RuntimeMinutes, &c.NumVotes)
+	if err != nil {
+		return nil, err
+	}
+	c.ItemType = catalogTitleTypes[c.Type]
+	return &c, nil
+}
+
+// catalogAliases returns the distinct alternate titles of a catalog title,
+// original title first, up to CATALOG_MAX_ALIASES (default 3). Every alias is
+// searched on every site (per missing episode for series), so each one adds a
+// full round of searches to the item.
+func catalogAliases(c *CatalogTitle) ([]string, error) {
+	rows, err := db.Query(`
+        SELECT title FROM catalog_akas WHERE title_id = $1
+        GROUP BY title ORDER BY COUNT(*) DESC, title ASC
+    `, c.ID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	max := getenvInt("CATALOG_MAX_ALIASES", 3)
+	seen := map[string]bool{normalize(c.Title): true}
+	var out []string
+	add := func(t string) {
+		if n := normalize(t); n != "" && !seen[n] && len(out) < max {
+			seen[n] = true
+			out = append(out, t)
+		}
+	}
+	if c.OriginalTitle != "" {
+		add(c.OriginalTitle)
+	}
+	for rows.Next() {
+		var t string
+		if err := rows.Scan(&t); err != nil {
+			return nil, err
+		}
+		add(t)
+	}
+	return out, rows.Err()
+}
+
+// catalogResolution is the outcome of resolving item text against the catalog.
+type catalogResolution struct {
+	Title       *CatalogTitle  // resolved title, nil if none
+	Suggestions []CatalogTitle // set when the text is ambiguous
+}
+
+// resolveCatalogTitle resolves item text to a single catalog title. The text
+// is ambiguous when several titles match and none stands out: only a year
+// match or a title with several times the votes of the runner-up wins.
+func resolveCatalogTitle(text string) (catalogResolution, error) {
+	matches, err := searchCatalog(text, 10)
+	if err != nil || len(matches) == 0 {
+		return catalogResolution{}, err
+	}
+	if len(matches) == 1 {
+		return catalogResolution{Title: &matches[0]}, nil
+	}
+
+	year, _ := strconv.Atoi(extractYear(text))
+	if year != 0 {
+		var exact []CatalogTitle
+		for _, m := range matches {
+			if m.Year == year {
+				exact = append(exact, m)
+			}
+		}
+		if len(exact) == 1 {
+			return catalogResolution{Title: &exact[0]}, nil
+		}
+	}
+
+	sort.SliceStable(matches, func(i, j int) bool { return matches[i].NumVotes > matches[j].NumVotes })
+	if matches[1].NumVotes > 0 && matches[0].NumVotes >= 10*matches[1].NumVotes {
+		return catalogResolution{Title: &matches[0]}, nil
+	}
+	return catalogResolution{Suggestions: matches}, nil
+}
+
+// catalogEnabled reports whether a catalog has been imported.
+func catalogEnabled() bool {
+	var exists bool
+	_ = db.QueryRow(`SELECT EXISTS (SELECT 1 FROM catalog_titles)`).Scan(&exists)
+	return exists
+}
+
+// catalogSearchHandler serves GET /api/catalog/search?q=.
+func catalogSearchHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	q := strings.TrimSpace(r.URL.Query().Get("q"))
+	if q == "" {
+		http.Error(w, "q required", http.StatusBadRequest)
+		return
+	}
+	matches, err := searchCatalog(q, 20)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if matches == nil {
+		matches = []CatalogTitle{}
+	}
+	writeJSON(w, matches)
+}
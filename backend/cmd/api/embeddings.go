@@ -0,0 +1,189 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/lib/pq"
+)
+
+// Title matchers, selected with MATCHER.
+const (
+	matcherFuzzy     = "fuzzy"
+	matcherEmbedding = "embedding"
+)
+
+// titleMatcher is how matchName compares an item name with a candidate title
+// when entity matching doesn't decide.
+type titleMatcher struct {
+	Kind               string
+	FuzzyThreshold     float64
+	EmbeddingThreshold float64
+}
+
+// currentTitleMatcher reads MATCHER (fuzzy or embedding, default fuzzy),
+// FUZZY_THRESHOLD and EMBEDDING_THRESHOLD.
+func currentTitleMatcher() titleMatcher {
+	kind := strings.ToLower(strings.TrimSpace(os.Getenv("MATCHER")))
+	if kind != matcherEmbedding {
+		kind = matcherFuzzy
+	}
+	return titleMatcher{
+		Kind:               kind,
+		FuzzyThreshold:     getenvFloat("FUZZY_THRESHOLD", 0.78),
+		EmbeddingThreshold: getenvFloat("EMBEDDING_THRESHOLD", 0.85),
+	}
+}
+
+func (m titleMatcher) String() string {
+	if m.Kind == matcherEmbedding {
+		return fmt.Sprintf("embedding (threshold=%.2f)", m.EmbeddingThreshold)
+	}
+	return fmt.Sprintf("fuzzy (threshold=%.2f)", m.FuzzyThreshold)
+}
+
+// matchEmbedding compares the item name and the candidate's title by cosine
+// similarity of their embeddings, then checks the year like the entity
+// matcher does. ok is false when the embeddings couldn't be computed, in
+// which case the caller falls back to fuzzy matching.
+func matchEmbedding(nameWithoutYear, itemYear, title string, entities []Entity, threshold float64, trace *candidateTrace) (matched, ok bool) {
+	candTitle, candYear := candidateTitleYear(title, entities)
+
+	sim, err := embeddingSimilarity(nameWithoutYear, candTitle)
+	if err != nil {
+		trace.logf("EMBEDDING_FAILED item=%q title=%q: %v - falling back to fuzzy\n", nameWithoutYear, candTitle, err)
+		trace.stage("embedding", false, "embeddings unavailable, falling back to fuzzy")
+		return false, false
+	}
+	trace.logf("EMBEDDING_SIMILARITY=%.3f (threshold=%.2f) item=%q title=%q\n", sim, threshold, nameWithoutYear, candTitle)
+	trace.stage("embedding", sim >= threshold, fmt.Sprintf("%q vs %q: %.3f (threshold %.2f)", nameWithoutYear, candTitle, sim, threshold))
+	if sim < threshold {
+		return false, true
+	}
+
+	if itemYear == "" {
+		return true, true
+	}
+	if candYear == "" {
+		trace.logf("NO_YEAR item_year=%s title=%q - REJECTED\n", itemYear, title)
+		trace.stage("year", false, "no year in title, expected "+itemYear)
+		return false, true
+	}
+	if candYear != itemYear {
+		trace.logf("YEAR_MISMATCH item_year=%s title_year=%s - REJECTED\n", itemYear, candYear)
+		trace.stage("year", false, fmt.Sprintf("%s, expected %s", candYear, itemYear))
+		return false, true
+	}
+	trace.stage("year", true, itemYear)
+	return true, true
+}
+
+// candidateTitleYear picks the film title and year out of a candidate: the
+// extracted entities if there are any, else the release parser, else the raw
+// title with its year removed.
+func candidateTitleYear(title string, entities []Entity) (string, string) {
+	if e := findEntityByType(entities, entityFilmTitle); e != nil {
+		year := ""
+		if y := findEntityByType(entities, entityYear); y != nil {
+			year = y.Text
+		}
+		return e.Text, year
+	}
+	if info := parseReleaseName(title); info.Parsed && info.Title != "" {
+		year := ""
+		if info.Year > 0 {
+			year = strconv.Itoa(info.Year)
+		}
+		return info.Title, year
+	}
+	return removeYear(title), extractYear(title)
+}
+
+// embeddingSimilarity returns the cosine similarity of the embeddings of a and b.
+func embeddingSimilarity(a, b string) (float64, error) {
+	va, err := embedText(a)
+	if err != nil {
+		return 0, err
+	}
+	vb, err := embedText(b)
+	if err != nil {
+		return 0, err
+	}
+	return cosineSimilarity(va, vb), nil
+}
+
+func cosineSimilarity(a, b []float64) float64 {
+	if len(a) == 0 || len(a) != len(b) {
+		return 0
+	}
+	var dot, na, nb float64
+	for i := range a {
+		dot += a[i] * b[i]
+		na += a[i] * a[i]
+		nb += b[i] * b[i]
+	}
+	if na == 0 || nb == 0 {
+		return 0
+	}
+	return dot / (math.Sqrt(na) * math.Sqrt(nb))
+}
+
+func embeddingModel() string {
+	if m := strings.TrimSpace(os.Getenv("OLLAMA_EMBED_MODEL")); m != "" {
+		return m
+	}
+	return "nomic-embed-text"
+}
+
+// embedText returns the embedding of a title. Titles are normalized first so
+// spelling variants share a vector, and vectors are cached in the embeddings
+// table per model.
+func embedText(text string) ([]float64, error) {
+	key := normalize(text)
+	if key == "" {
+		return nil, fmt.Errorf("nothing to embed in %q", text)
+	}
+	model := embeddingModel()
+
+	var cached pq.Float64Array
+	err := db.QueryRow(`SELECT vector FROM embeddings WHERE model = $1 AND text = $2`, model, key).Scan(&cached)
+	if err == nil {
+		return cached, nil
+	}
+	if err != sql.ErrNoRows {
+		return nil, err
+	}
+
+	vec, err := fetchEmbedding(model, key)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := db.Exec(`
+        INSERT INTO embeddings(model, text, vector) VALUES ($1, $2, $3)
+        ON CONFLICT (model, text) DO NOTHING
+    `, model, key, pq.Float64Array(vec)); err != nil {
+		log.Printf("Failed to cache embedding for %q: %v\n", key, err)
+	}
+	return vec, nil
+}
+
+// fetchEmbedding calls Ollama's /api/embeddings endpoint.
+func fetchEmbedding(model, text string) ([]float64, error) {
+	var out struct {
+		Embedding []float64 `json:"embedding"`
+	}
+	req := map[string]string{"model": model, "prompt": text}
+	if err := embeddingLLM.postJSON(context.Background(), ollamaBaseURL()+"/api/embeddings", "", req, &out); err != nil {
+		return nil, fmt.Errorf("Ollama embeddings: %w", err)
+	}
+	if len(out.Embedding) == 0 {
+		return nil, fmt.Errorf("Ollama returned an empty embedding")
+	}
+	return out.Embedding, nil
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/lib/pq"
+)
+
+// embeddingStub is a stub of Ollama's /api/embeddings endpoint backed by an
+// in-memory embeddings table.
+type embeddingStub struct {
+	vectors map[string][]float64 // by prompt; other prompts get a 500
+	calls   atomic.Int32
+
+	mu    sync.Mutex
+	cache map[string]string // model + "|" + text -> vector as stored by pq
+}
+
+// newEmbeddingStub starts the stub server and the fake database for a test.
+func newEmbeddingStub(t *testing.T, vectors map[string][]float64) *embeddingStub {
+	t.Helper()
+	s := &embeddingStub{vectors: vectors, cache: map[string]string{}}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/embeddings" {
+			http.NotFound(w, r)
+			return
+		}
+		s.calls.Add(1)
+		var req struct{ Model, Prompt string }
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		vec, ok := s.vectors[req.Prompt]
+		if !ok {
+			http.Error(w, "model failed", http.StatusInternalServerError)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]any{"embedding": vec})
+	}))
+	t.Cleanup(srv.Close)
+
+	t.Setenv("OLLAMA_URL", srv.URL)
+	t.Setenv("OLLAMA_EMBED_MODEL", "stub-embed")
+	t.Setenv("LLM_MAX_RETRIES", "0")
+	useFreshLLM(t)
+	useFakeDB(t, s.query)
+	return s
+}
+
+func (s *embeddingStub) query(query string, args []driver.Value) ([]string, [][]driver.Value, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch {
+	case strings.Contains(query, "SELECT vector FROM embeddings"):
+		v, ok := s.cache[fmt.Sprint(args[0], "|", args[1])]
+		if !ok {
+			return []string{"vector"}, nil, nil
+		}
+		return []string{"vector"}, [][]driver.Value{{v}}, nil
+	case strings.Contains(query, "INSERT INTO embeddings"):
+		s.cache[fmt.Sprint(args[0], "|", args[1])] = fmt.Sprint(args[2])
+		return nil, nil, nil
+	}
+	return nil, nil, fmt.Errorf("unexpected query: %s", query)
+}
+
+// cached stores a vector in the fake embeddings table.
+func (s *embeddingStub) cached(text string, vec []float64) {
+	v, _ := pq.Float64Array(vec).Value()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.cache["stub-embed|"+text] = v.(string)
+}
+
+func stageNames(t *candidateTrace) []string {
+	var out []string
+	for _, s := range t.stages {
+		out = append(out, fmt.Sprintf("%s=%v", s.Stage, s.Passed))
+	}
+	return out
+}
+
+func TestMatchEmbeddingThreshold(t *testing.T) {
+	tests := []struct {
+		name      string
+		threshold float64
+		want      bool
+	}{
+		{"above threshold", 0.75, true},
+		{"below threshold", 0.85, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// cos([1 0], [0.8 0.6]) = 0.8
+			newEmbeddingStub(t, map[string][]float64{
+				"the matrix":  {1, 0},
+				"the matrixx": {0.8, 0.6},
+			})
+			trace := &candidateTrace{quiet: true}
+			matched, ok := matchEmbedding("The Matrix", "", "The Matrixx", nil, tt.threshold, trace)
+			if !ok {
+				t.Fatalf("embeddings unavailable: %v", stageNames(trace))
+			}
+			if matched != tt.want {
+				t.Errorf("matched = %v, want %v (stages %v)", matched, tt.want, stageNames(trace))
+			}
+		})
+	}
+}
+
+func TestMatchEmbeddingYear(t *testing.T) {
+	tests := []struct {
+		name      string
+		itemYear  string
+		title     string
+		want      bool
+		lastStage string
+	}{
+		{"same year", "1999", "The.Matrix.1999.1080p.BluRay.x264-GRP", true, "year"},
+		{"other year", "2003", "The.Matrix.1999.1080p.BluRay.x264-GRP", false, "year"},
+		{"no year in title", "1999", "The Matrix", false, "year"},
+		{"no year on item", "", "The Matrix", true, "embedding"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			newEmbeddingStub(t, map[string][]float64{"the matrix": {1, 0}})
+			trace := &candidateTrace{quiet: true}
+			matched, ok := matchEmbedding("The Matrix", tt.itemYear, tt.title, nil, 0.9, trace)
+			if !ok {
+				t.Fatalf("embeddings unavailable: %v", stageNames(trace))
+			}
+			if matched != tt.want {
+				t.Errorf("matched = %v, want %v (stages %v)", matched, tt.want, stageNames(trace))
+			}
+			if got := lastStage(trace); got != tt.lastStage {
+				t.Errorf("last stage = %q, want %q", got, tt.lastStage)
+			}
+		})
+	}
+}
+
+func TestMatchEmbeddingFallsBackToFuzzy(t *testing.T) {
+	tests := []struct {
+		name    string
+		vectors map[string][]float64
+	}{
+		{"server error", map[string][]float64{}},
+		{"empty vector", map[string][]float64{"the matrix": {}, "the matrix 1999": {}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			newEmbeddingStub(t, tt.vectors)
+			trace := &candidateTrace{quiet: true}
+			if _, ok := matchEmbedding("The Matrix", "1999", "The Matrix 1999", nil, 0.9, trace); ok {
+				t.Fatalf("ok = true, want false when embeddings fail")
+			}
+
+			matcher := titleMatcher{Kind: matcherEmbedding, FuzzyThreshold: 0.78, EmbeddingThreshold: 0.9}
+			trace = &candidateTrace{quiet: true}
+			if !matchName("The Matrix 1999", "", "The Matrix 1999", nil, false, matcher, trace) {
+				t.Errorf("matchName = false, want the fuzzy matcher to accept (stages %v)", stageNames(trace))
+			}
+			if got := stageNames(trace); len(got) != 2 || got[0] != "embedding=false" || got[1] != "fuzzy=true" {
+				t.Errorf("stages = %v, want [embedding=false fuzzy=true]", got)
+			}
+		})
+	}
+}
+
+func TestEmbedTextCache(t *testing.T) {
+	t.Run("hit", func(t *testing.T) {
+		stub := newEmbeddingStub(t, map[string][]float64{})
+		stub.cached("the matrix", []float64{0.5, 0.25})
+		vec, err := embedText("The Matrix")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(vec) != 2 || vec[0] != 0.5 || vec[1] != 0.25 {
+			t.Errorf("vector = %v, want the cached [0.5 0.25]", vec)
+		}
+		if n := stub.calls.Load(); n != 0 {
+			t.Errorf("server called %d time(s) on a cache hit", n)
+		}
+	})
+
+	t.Run("miss is stored", func(t *testing.T) {
+		stub := newEmbeddingStub(t, map[string][]float64{"the matrix": {1, 2}})
+		for i := 0; i < 2; i++ {
+			if _, err := embedText("The Matrix"); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if n := stub.calls.Load(); n != 1 {
+			t.Errorf("server called %d time(s), want 1", n)
+		}
+	})
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"log"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Canonical entity types. The release parser produces these directly; LLM
+// output is mapped onto them by validateEntities.
+const (
+	entityFilmTitle    = "FILM TITLE"
+	entityYear         = "YEAR"
+	entitySeason       = "SEASON"
+	entityEpisode      = "EPISODE"
+	entityResolution   = "RESOLUTION"
+	entitySource       = "SOURCE"
+	entityVideoCodec   = "VIDEO CODEC"
+	entityAudio        = "AUDIO"
+	entityHDR          = "HDR"
+	entityReleaseGroup = "RELEASE GROUP"
+	entityRepack       = "REPACK"
+	entityProper       = "PROPER"
+	entityLanguage     = "LANGUAGE"
+	entityFileSize     = "FILE SIZE"
+	entitySeeds        = "SEEDS"
+	entityLeechers     = "LEECHERS"
+)
+
+var entityTypes = []string{
+	entityFilmTitle, entityYear, entitySeason, entityEpisode, entityResolution, entitySource, entityVideoCodec,
+	entityAudio, entityHDR, entityReleaseGroup, entityRepack, entityProper, entityLanguage, entityFileSize,
+	entitySeeds, entityLeechers,
+}
+
+// entityTypeAliases repairs the spellings LLMs come up with, keyed by the
+// type with spaces, dashes and underscores removed.
+var entityTypeAliases = map[string]string{
+	"TITLE": entityFilmTitle, "MOVIETITLE": entityFilmTitle, "MOVIE": entityFilmTitle, "FILM": entityFilmTitle,
+	"SHOWTITLE": entityFilmTitle, "SERIESTITLE": entityFilmTitle, "NAME": entityFilmTitle,
+	"RELEASEYEAR": entityYear,
+	"QUALITY":     entityResolution,
+	"VIDEOFORMAT": entityVideoCodec, "CODEC": entityVideoCodec, "FORMAT": entityVideoCodec,
+	"AUDIOCODEC": entityAudio, "AUDIOFORMAT": entityAudio,
+	"GROUP": entityReleaseGroup, "RELEASER": entityReleaseGroup,
+	"SIZE": entityFileSize, "FILESIZE": entityFileSize,
+	"SEEDERS": entitySeeds, "SEED": entitySeeds,
+	"LEECHES": entityLeechers, "LEECHER": entityLeechers, "PEERS": entityLeechers,
+}
+
+var entityTypeSeparators = strings.NewReplacer(" ", "", "_", "", "-", "")
+
+// canonicalEntityType maps a raw entity type to its canonical form, or
+// returns ok=false for types we don't know.
+func canonicalEntityType(raw string) (string, bool) {
+	key := entityTypeSeparators.Replace(strings.ToUpper(strings.TrimSpace(raw)))
+	if key == "" {
+		return "", false
+	}
+	for _, t := range entityTypes {
+		if key == entityTypeSeparators.Replace(t) {
+			return t, true
+		}
+	}
+	t, ok := entityTypeAliases[key]
+	return t, ok
+}
+
+// findEntityByType returns the first entity of a canonical type.
+func findEntityByType(entities []Entity, entityType string) *Entity {
+	for i := range entities {
+		if t, ok := canonicalEntityType(entities[i].Type); ok && t == entityType {
+			return &entities[i]
+		}
+	}
+	return nil
+}
+
+// entityStats reads file size, seeds and leechers out of entities, as text
+// the way the matches table stores them.
+func entityStats(entities []Entity) (fileSize, seeds, leechers string) {
+	for _, e := range entities {
+		t, _ := canonicalEntityType(e.Type)
+		switch t {
+		case entityFileSize:
+			fileSize = e.Text
+		case entitySeeds:
+			seeds = e.Text
+		case entityLeechers:
+			leechers = e.Text
+		}
+	}
+	return fileSize, seeds, leechers
+}
+
+var (
+	digitsOnly  = regexp.MustCompile(`^\d+$`)
+	firstNumber = regexp.MustCompile(`\d+`)
+	yearToken   = regexp.MustCompile(`\b(1[89]\d{2}|2\d{3})\b`)
+)
+
+// validateEntities checks entities an LLM extracted from title. Types are
+// mapped to the canonical enum (unknown types are dropped), numeric types
+// are parsed into Value (years within 1888..next year, sizes in bytes,
+// counts as non-negative ints) and every entity's text must occur in the
+// title: its span is checked and repaired, and text that isn't in the title
+// at all is rejected as hallucinated.
+func validateEntities(title string, entities []Entity) []Entity {
+	out := make([]Entity, 0, len(entities))
+	for _, e := range entities {
+		e.Text = strings.TrimSpace(e.Text)
+		t, ok := canonicalEntityType(e.Type)
+		switch {
+		case !ok:
+			log.Printf("ENTITY_DROPPED unknown type %q (text %q) in %q\n", e.Type, e.Text, title)
+			continue
+		case e.Text == "":
+			continue
+		}
+		e.Type = t
+
+		if !locateEntity(title, &e) {
+			log.Printf("ENTITY_HALLUCINATED type=%s text=%q not found in %q - dropped\n", e.Type, e.Text, title)
+			continue
+		}
+		if !parseEntityValue(&e) {
+			log.Printf("ENTITY_INVALID type=%s text=%q in %q - dropped\n", e.Type, e.Text, title)
+			continue
+		}
+		if e.Confidence < 0 || e.Confidence > 1 {
+			e.Confidence = 0
+		}
+		out = append(out, e)
+	}
+	return out
+}
+
+// parseEntityValue fills the typed value of numeric entities and reports
+// whether the text is valid for its type.
+func parseEntityValue(e *Entity) bool {
+	var v int64
+	switch e.Type {
+	case entityYear:
+		m := yearToken.FindString(e.Text)
+		y, _ := strconv.Atoi(m)
+		if y < 1888 || y > time.Now().Year()+1 {
+			return false
+		}
+		e.Text, v = m, int64(y)
+	case entitySeason, entityEpisode:
+		n, ok := parseCount(firstNumber.FindString(e.Text)) // "S02", "Episode 5"
+		if !ok || n == 0 {
+			return false
+		}
+		v = n
+	case entitySeeds, entityLeechers:
+		n, ok := parseCount(e.Text)
+		if !ok {
+			return false
+		}
+		e.Text, v = strconv.FormatInt(n, 10), n
+	case entityFileSize:
+		n, ok := parseSizeBytes(e.Text)
+		if !ok || n <= 0 {
+			return false
+		}
+		v = n
+	default:
+		return true
+	}
+	e.Value = &v
+	return true
+}
+
+// parseCount reads a non-negative integer, allowing thousands separators.
+func parseCount(s string) (int64, bool) {
+	s = strings.NewReplacer(",", "", ".", "", " ", "").Replace(strings.TrimSpace(s))
+	if !digitsOnly.MatchString(s) {
+		return 0, false
+	}
+	n, err := strconv.ParseInt(s, 10, 64)
+	return n, err == nil
+}
+
+// locateEntity checks that the entity's text occurs in title. A span that
+// points at the text is kept, otherwise the span is set to the first
+// case-insensitive occurrence. Text that only occurs with different
+// separators ("The Matrix" in "The.Matrix.1999", "4.3 GB" in "4.3GB") is
+// accepted with its span cleared.
+func locateEntity(title string, e *Entity) bool {
+	if e.Start >= 0 && e.End > e.Start && e.End <= len(title) && strings.EqualFold(title[e.Start:e.End], e.Text) {
+		return true
+	}
+	// Spans are byte offsets, so only search the lowercased title when
+	// lowercasing kept its length
+	if lower := strings.ToLower(title); len(lower) == len(title) {
+		if i := strings.Index(lower, strings.ToLower(e.Text)); i >= 0 && i+len(e.Text) <= len(title) {
+			e.Start, e.End = i, i+len(e.Text)
+			return true
+		}
+	}
+	e.Start, e.End = 0, 0
+	if text := normalize(e.Text); text != "" && strings.Contains(" "+normalize(title)+" ", " "+text+" ") {
+		return true
+	}
+	compact := func(s string) string { return strings.Join(strings.Fields(strings.ToLower(s)), "") }
+	return strings.Contains(compact(title), compact(e.Text))
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+)
+
+// promptCompleter is implemented by the LLM backends: it sends a prompt and
+// returns the raw (JSON) completion.
+type promptCompleter interface {
+	generate(ctx context.Context, prompt string) (string, error)
+}
+
+// entityBatchSize is ENTITY_BATCH_SIZE, the number of titles per LLM prompt
+// (default 8; 1 sends every title on its own).
+func entityBatchSize() int {
+	n := getenvInt("ENTITY_BATCH_SIZE", 8)
+	if n < 1 {
+		return 1
+	}
+	return n
+}
+
+// extractedEntities is the outcome of extracting one title ahead of matching.
+type extractedEntities struct {
+	resp   *EntityExtractionResponse
+	source string
+	err    error
+}
+
+// entityBatch holds the LLM extractions done ahead of matching for the
+// results of one search, by title.
+type entityBatch map[string]extractedEntities
+
+// extract returns the prefetched entities of a title, or extracts them now.
+func (b entityBatch) extract(ctx context.Context, title string, stats *workerRunStats) (*EntityExtractionResponse, string, error) {
+	if e, ok := b[title]; ok {
+		return e.resp, e.source, e.err
+	}
+	return extractEntitiesForTitle(ctx, title, stats)
+}
+
+// prefetchEntities extracts the entities of every title the release parser
+// can't handle, ENTITY_BATCH_SIZE titles per LLM prompt. Titles found in the
+// entity cache are served from it. Batch entries that are missing or fail
+// validation are extracted on their own.
+func prefetchEntities(ctx context.Context, titles []string, stats *workerRunStats) entityBatch {
+	backend := entityBackend()
+	if backend == extractorParser {
+		return nil
+	}
+	extractor := newEntityExtractor(backend)
+	completer, ok := extractor.(promptCompleter)
+	if !ok || entityBatchSize() <= 1 {
+		return nil
+	}
+	if !llm.available() {
+		log.Printf("LLM circuit breaker open, skipping entity extraction for %d titles\n", len(titles))
+		return nil
+	}
+
+	batch := entityBatch{}
+	var pending []string
+	seen := map[string]bool{}
+	for _, title := range titles {
+		if seen[title] || parseReleaseName(title).Parsed {
+			continue
+		}
+		seen[title] = true
+		key := newEntityCacheKey(extractor, title)
+		if key.Title == "" {
+			continue
+		}
+		if resp := lookupEntityCache(key); resp != nil {
+			stats.entityCacheHit()
+			batch[title] = extractedEntities{resp: resp, source: backend}
+			continue
+		}
+		stats.entityCacheMiss()
+		pending = append(pending, title)
+	}
+
+	size := entityBatchSize()
+	for start := 0; start < len(pending); start += size {
+		end := start + size
+		if end > len(pending) {
+			end = len(pending)
+		}
+		chunk := pending[start:end]
+
+		log.Printf(">>> CALLING LLM (%s) for entity extraction of %d titles in one batch\n", backend, len(chunk))
+		began := time.Now()
+		stats.llmCall()
+		results, err := extractBatch(ctx, completer, batchEntityPrompt(chunk), chunk)
+		recordExtraction(backend+"_batch", time.Since(began), err)
+		if err != nil {
+			log.Printf("Batch entity extraction failed, extracting %d titles one by one: %v\n", len(chunk), err)
+		}
+
+		for i, title := range chunk {
+			key := newEntityCacheKey(extractor, title)
+			if resp, ok := results[i]; ok {
+				storeEntityCache(key, resp)
+				batch[title] = extractedEntities{resp: resp, source: backend + " (batch)"}
+				continue
+			}
+			if err == nil {
+				log.Printf("BATCH_ENTRY_INVALID index=%d title=%q - extracting on its own\n", i, title)
+			}
+			stats.llmCall()
+			resp, err := extractWithStats(ctx, extractor, title)
+			if err == nil {
+				storeEntityCache(key, resp)
+			}
+			batch[title] = extractedEntities{resp: resp, source: backend, err: err}
+		}
+	}
+	return batch
+}
+
+// extractBatch sends the batch prompt for titles and returns the valid
+// entries by index into titles.
+func extractBatch(ctx context.Context, completer promptCompleter, prompt string, titles []string) (map[int]*EntityExtractionResponse, error) {
+	raw, err := completer.generate(ctx, prompt)
+	if err != nil {
+		return nil, err
+	}
+
+	type batchEntry struct {
+		Index    *int     `json:"index"`
+		Entities []Entity `json:"entities"`
+	}
+	trimmed := strings.TrimSpace(raw)
+	var entries []batchEntry
+	if err := json.Unmarshal([]byte(trimmed), &entries); err != nil {
+		var wrapped struct {
+			Results []batchEntry `json:"results"`
+		}
+		if err := json.Unmarshal([]byte(trimmed), &wrapped); err != nil {
+			return nil, fmt.Errorf("LLM returned invalid JSON: %w", err)
+		}
+		entries = wrapped.Results
+	}
+
+	out := map[int]*EntityExtractionResponse{}
+	for _, e := range entries {
+		if e.Index == nil || *e.Index < 0 || *e.Index >= len(titles) {
+			continue
+		}
+		if _, dup := out[*e.Index]; dup {
+			// Two answers for one title: trust neither
+			out[*e.Index] = nil
+			continue
+		}
+		entities := validateEntities(titles[*e.Index], e.Entities)
+		if !validBatchEntities(entities) {
+			out[*e.Index] = nil
+			continue
+		}
+		out[*e.Index] = &EntityExtractionResponse{Entities: entities}
+	}
+	for i, resp := range out {
+		if resp == nil {
+			delete(out, i)
+		}
+	}
+	return out, nil
+}
+
+// validBatchEntities checks a validated batch entry: it must still have a
+// film title, which validateEntities only keeps if it occurs in the title,
+// so entries the model attached to the wrong index fail.
+func validBatchEntities(entities []Entity) bool {
+	return findEntityByType(entities, entityFilmTitle) != nil
+}
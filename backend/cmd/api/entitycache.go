@@ -0,0 +1,170 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+)
+
+// entityCacheKey identifies cached entities: the normalized title plus the
+// backend, model and prompt that produced them.
+type entityCacheKey struct {
+	Title         string
+	Backend       string
+	Model         string
+	PromptVersion string
+}
+
+func newEntityCacheKey(e EntityExtractor, title string) entityCacheKey {
+	return entityCacheKey{Title: normalize(title), Backend: e.Name(), Model: e.Model(), PromptVersion: entityPromptVersion()}
+}
+
+// entityCacheTTLDays is ENTITY_CACHE_TTL_DAYS (default 30; 0 keeps entries forever).
+func entityCacheTTLDays() int {
+	return getenvInt("ENTITY_CACHE_TTL_DAYS", 30)
+}
+
+// lookupEntityCache returns cached entities, or nil if there are none that
+// are younger than the TTL.
+func lookupEntityCache(key entityCacheKey) *EntityExtractionResponse {
+	var raw []byte
+	err := db.QueryRow(`
+        SELECT entities FROM entity_cache
+        WHERE title_key = $1 AND backend = $2 AND model = $3 AND prompt_version = $4
+          AND ($5 <= 0 OR created_at >= CURRENT_TIMESTAMP - make_interval(days => $5))
+    `, key.Title, key.Backend, key.Model, key.PromptVersion, entityCacheTTLDays()).Scan(&raw)
+	if err != nil {
+		if err != sql.ErrNoRows {
+			log.Printf("Entity cache lookup failed for %q: %v\n", key.Title, err)
+		}
+		return nil
+	}
+	var entities []Entity
+	if err := json.Unmarshal(raw, &entities); err != nil {
+		return nil
+	}
+	return &EntityExtractionResponse{Entities: entities}
+}
+
+func storeEntityCache(key entityCacheKey, resp *EntityExtractionResponse) {
+	entitiesJSON, _ := json.Marshal(resp.Entities)
+	if _, err := db.Exec(`
+        INSERT INTO entity_cache(title_key, backend, model, prompt_version, entities)
+        VALUES ($1, $2, $3, $4, $5::jsonb)
+        ON CONFLICT (title_key, backend, model, prompt_version)
+        DO UPDATE SET entities = EXCLUDED.entities, created_at = CURRENT_TIMESTAMP
+    `, key.Title, key.Backend, key.Model, key.PromptVersion, string(entitiesJSON)); err != nil {
+		log.Printf("Failed to cache entities for %q: %v\n", key.Title, err)
+	}
+}
+
+// extractCached serves entities from the cache, calling the extractor and
+// storing its result on a miss. Failures are not cached.
+func extractCached(ctx context.Context, e EntityExtractor, title string, stats *workerRunStats) (*EntityExtractionResponse, bool, error) {
+	key := newEntityCacheKey(e, title)
+	if key.Title != "" {
+		if resp := lookupEntityCache(key); resp != nil {
+			stats.entityCacheHit()
+			return resp, true, nil
+		}
+	}
+	stats.entityCacheMiss()
+
+	stats.llmCall()
+	resp, err := extractWithStats(ctx, e, title)
+	if err != nil {
+		return nil, false, err
+	}
+	if key.Title != "" {
+		storeEntityCache(key, resp)
+	}
+	return resp, false, nil
+}
+
+// pruneEntityCache drops expired entries and entries of the current backend
+// made with another model or prompt version.
+func pruneEntityCache() {
+	backend := entityBackend()
+	model := newEntityExtractor(backend).Model()
+	res, err := db.Exec(`
+        DELETE FROM entity_cache
+        WHERE ($1 > 0 AND created_at < CURRENT_TIMESTAMP - make_interval(days => $1))
+           OR (backend = $2 AND (model <> $3 OR prompt_version <> $4))
+    `, entityCacheTTLDays(), backend, model, entityPromptVersion())
+	if err != nil {
+		log.Printf("Failed to prune entity cache: %v\n", err)
+		return
+	}
+	if n, _ := res.RowsAffected(); n > 0 {
+		log.Printf("Pruned %d stale entity cache entries\n", n)
+	}
+}
+
+// entityCacheHandler serves GET /api/entity-cache (entry counts per backend,
+// model and prompt version) and DELETE /api/entity-cache, which invalidates
+// everything or only the entries matching ?backend=, ?model= and ?title=.
+func entityCacheHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		rows, err := db.Query(`
+            SELECT backend, model, prompt_version, COUNT(*), MIN(created_at), MAX(created_at)
+            FROM entity_cache
+            GROUP BY backend, model, prompt_version
+            ORDER BY backend, model, prompt_version
+        `)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		defer rows.Close()
+
+		type cacheGroup struct {
+			Backend       string `json:"backend"`
+			Model         string `json:"model"`
+			PromptVersion string `json:"prompt_version"`
+			Entries       int    `json:"entries"`
+			Oldest        string `json:"oldest"`
+			Newest        string `json:"newest"`
+		}
+		groups := []cacheGroup{}
+		for rows.Next() {
+			var g cacheGroup
+			if err := rows.Scan(&g.Backend, &g.Model, &g.PromptVersion, &g.Entries, &g.Oldest, &g.Newest); err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			groups = append(groups, g)
+		}
+		backend := entityBackend()
+		writeJSON(w, map[string]any{
+			"backend":        backend,
+			"model":          newEntityExtractor(backend).Model(),
+			"prompt_version": entityPromptVersion(),
+			"ttl_days":       entityCacheTTLDays(),
+			"groups":         groups,
+		})
+
+	case http.MethodDelete:
+		q := r.URL.Query()
+		title := ""
+		if t := strings.TrimSpace(q.Get("title")); t != "" {
+			title = normalize(t)
+		}
+		res, err := db.Exec(`
+            DELETE FROM entity_cache
+            WHERE ($1 = '' OR backend = $1) AND ($2 = '' OR model = $2) AND ($3 = '' OR title_key = $3)
+        `, strings.TrimSpace(q.Get("backend")), strings.TrimSpace(q.Get("model")), title)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		n, _ := res.RowsAffected()
+		writeJSON(w, map[string]any{"deleted": n})
+
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
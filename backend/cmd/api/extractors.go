@@ -0,0 +1,288 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// EntityExtractor turns a torrent title into entities (FILM TITLE, YEAR,
+// RESOLUTION, ...).
+type EntityExtractor interface {
+	Name() string
+	Model() string // model the backend is configured with, "" for the parser
+	Extract(ctx context.Context, title string) (*EntityExtractionResponse, error)
+}
+
+// Entity extraction backends, selected with ENTITY_EXTRACTOR.
+const (
+	extractorParser = "parser"
+	extractorOllama = "ollama"
+	extractorOpenAI = "openai"
+)
+
+var extractorBackends = []string{extractorParser, extractorOllama, extractorOpenAI}
+
+// entityBackend returns the configured ENTITY_EXTRACTOR. Without one,
+// ENTITY_LLM_FALLBACK=true still selects Ollama as before.
+func entityBackend() string {
+	backend := strings.ToLower(strings.TrimSpace(os.Getenv("ENTITY_EXTRACTOR")))
+	switch backend {
+	case extractorParser, extractorOllama, extractorOpenAI:
+		return backend
+	case "":
+		if strings.ToLower(os.Getenv("ENTITY_LLM_FALLBACK")) == "true" {
+			return extractorOllama
+		}
+		return extractorParser
+	default:
+		log.Printf("Unknown ENTITY_EXTRACTOR %q, using the release parser\n", backend)
+		return extractorParser
+	}
+}
+
+// llmFallbackEnabled reports whether titles the parser can't handle are sent
+// to an LLM backend.
+func llmFallbackEnabled() bool {
+	return entityBackend() != extractorParser
+}
+
+// newEntityExtractor builds the extractor for a backend from its environment
+// settings. Settings are read on every call so they can change at runtime.
+func newEntityExtractor(backend string) EntityExtractor {
+	switch backend {
+	case extractorOllama:
+		return &ollamaExtractor{url: ollamaBaseURL(), model: ollamaModelName()}
+	case extractorOpenAI:
+		return &openAIExtractor{url: openAIBaseURL(), model: os.Getenv("OPENAI_MODEL"), apiKey: os.Getenv("OPENAI_API_KEY")}
+	default:
+		return parserExtractor{}
+	}
+}
+
+func ollamaBaseURL() string {
+	if u := os.Getenv("OLLAMA_URL"); u != "" {
+		return u
+	}
+	return "http://localhost:11434"
+}
+
+func openAIBaseURL() string {
+	if u := strings.TrimRight(os.Getenv("OPENAI_BASE_URL"), "/"); u != "" {
+		return u
+	}
+	return "http://localhost:8080/v1"
+}
+
+// ollamaModelName is the model switched to through the admin API, else
+// OLLAMA_MODEL (default llama2).
+func ollamaModelName() string {
+	if m := activeOllamaModelName(); m != "" {
+		return m
+	}
+	if m := os.Getenv("OLLAMA_MODEL"); m != "" {
+		return m
+	}
+	return "llama2"
+}
+
+// -------------------- Backends --------------------
+
+// parserExtractor is the built-in, deterministic release-name parser.
+type parserExtractor struct{}
+
+func (parserExtractor) Name() string  { return extractorParser }
+func (parserExtractor) Model() string { return "" }
+
+func (parserExtractor) Extract(_ context.Context, title string) (*EntityExtractionResponse, error) {
+	return &EntityExtractionResponse{Entities: parseReleaseName(title).Entities()}, nil
+}
+
+// ollamaExtractor calls Ollama's /api/generate in JSON mode.
+type ollamaExtractor struct {
+	url   string
+	model string
+}
+
+func (e *ollamaExtractor) Name() string  { return extractorOllama }
+func (e *ollamaExtractor) Model() string { return e.model }
+
+func (e *ollamaExtractor) Extract(ctx context.Context, title string) (*EntityExtractionResponse, error) {
+	raw, err := e.generate(ctx, entityPrompt(title))
+	if err != nil {
+		return nil, err
+	}
+	return parseEntityJSON(title, raw)
+}
+
+func (e *ollamaExtractor) generate(ctx context.Context, prompt string) (string, error) {
+	reqBody := OllamaRequest{
+		Model:  e.model,
+		Prompt: prompt,
+		Stream: false,
+		Format: "json", // Force JSON output
+	}
+	var ollamaResp OllamaResponse
+	if err := llm.postJSON(ctx, e.url+"/api/generate", "", reqBody, &ollamaResp); err != nil {
+		return "", fmt.Errorf("Ollama: %w", err)
+	}
+	return ollamaResp.Response, nil
+}
+
+// openAIExtractor calls an OpenAI-compatible /chat/completions endpoint
+// (llama.cpp server, vLLM, LM Studio, ...).
+type openAIExtractor struct {
+	url    string // base URL including the version, e.g. http://localhost:8080/v1
+	model  string
+	apiKey string
+}
+
+func (e *openAIExtractor) Name() string  { return extractorOpenAI }
+func (e *openAIExtractor) Model() string { return e.model }
+
+type chatMessage struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+}
+
+type chatCompletionRequest struct {
+	Model          string            `json:"model,omitempty"`
+	Messages       []chatMessage     `json:"messages"`
+	Temperature    float64           `json:"temperature"`
+	ResponseFormat map[string]string `json:"response_format,omitempty"`
+}
+
+type chatCompletionResponse struct {
+	Choices []struct {
+		Message chatMessage `json:"message"`
+	} `json:"choices"`
+}
+
+func (e *openAIExtractor) Extract(ctx context.Context, title string) (*EntityExtractionResponse, error) {
+	raw, err := e.generate(ctx, entityPrompt(title))
+	if err != nil {
+		return nil, err
+	}
+	return parseEntityJSON(title, raw)
+}
+
+func (e *openAIExtractor) generate(ctx context.Context, prompt string) (string, error) {
+	reqBody := chatCompletionRequest{
+		Model:          e.model,
+		Messages:       []chatMessage{{Role: "user", Content: prompt}},
+		Temperature:    0,
+		ResponseFormat: map[string]string{"type": "json_object"},
+	}
+	var chatResp chatCompletionResponse
+	if err := llm.postJSON(ctx, e.url+"/chat/completions", e.apiKey, reqBody, &chatResp); err != nil {
+		return "", fmt.Errorf("chat completions: %w", err)
+	}
+	if len(chatResp.Choices) == 0 {
+		return "", fmt.Errorf("chat completions returned no choices")
+	}
+	return chatResp.Choices[0].Message.Content, nil
+}
+
+// parseEntityJSON reads the entities an LLM returned for title, either as an
+// object with an "entities" array or as the bare array, and validates them.
+func parseEntityJSON(title, raw string) (*EntityExtractionResponse, error) {
+	// Check if response is empty or whitespace-only
+	trimmed := strings.TrimSpace(raw)
+	if trimmed == "" {
+		return nil, fmt.Errorf("LLM returned empty response")
+	}
+
+	// Try to parse as array first (LLM sometimes returns array directly)
+	var entities []Entity
+	if err := json.Unmarshal([]byte(trimmed), &entities); err == nil {
+		return &EntityExtractionResponse{Entities: validateEntities(title, entities)}, nil
+	}
+
+	var entityResp EntityExtractionResponse
+	if err := json.Unmarshal([]byte(trimmed), &entityResp); err != nil {
+		log.Printf("LLM returned invalid JSON - treating as extraction failure\nError: %v\nResponse: %q", err, raw)
+		return nil, fmt.Errorf("LLM returned invalid JSON: %w", err)
+	}
+	entityResp.Entities = validateEntities(title, entityResp.Entities)
+	return &entityResp, nil
+}
+
+// -------------------- Stats --------------------
+
+// extractorStat counts the calls of one backend since startup.
+type extractorStat struct {
+	Calls          int64   `json:"calls"`
+	Failures       int64   `json:"failures"`
+	AvgLatencyMs   float64 `json:"avg_latency_ms"`
+	LastLatencyMs  int64   `json:"last_latency_ms"`
+	LastError      string  `json:"last_error,omitempty"`
+	LastErrorAt    string  `json:"last_error_at,omitempty"`
+	totalLatencyMs int64
+}
+
+var (
+	extractorStatsMu sync.Mutex
+	extractorStats   = map[string]*extractorStat{}
+)
+
+// extractWithStats runs an extractor and records its latency and outcome.
+func extractWithStats(ctx context.Context, e EntityExtractor, title string) (*EntityExtractionResponse, error) {
+	start := time.Now()
+	resp, err := e.Extract(ctx, title)
+	recordExtraction(e.Name(), time.Since(start), err)
+	return resp, err
+}
+
+// recordExtraction adds a call to the stats of a backend.
+func recordExtraction(name string, latency time.Duration, err error) {
+	elapsed := latency.Milliseconds()
+
+	extractorStatsMu.Lock()
+	defer extractorStatsMu.Unlock()
+	st := extractorStats[name]
+	if st == nil {
+		st = &extractorStat{}
+		extractorStats[name] = st
+	}
+	st.Calls++
+	st.totalLatencyMs += elapsed
+	st.AvgLatencyMs = float64(st.totalLatencyMs) / float64(st.Calls)
+	st.LastLatencyMs = elapsed
+	if err != nil {
+		st.Failures++
+		st.LastError = err.Error()
+		st.LastErrorAt = time.Now().UTC().Format(time.RFC3339)
+	}
+}
+
+// extractorStatsSnapshot copies the stats of every backend used so far.
+func extractorStatsSnapshot() map[string]extractorStat {
+	extractorStatsMu.Lock()
+	defer extractorStatsMu.Unlock()
+	out := make(map[string]extractorStat, len(extractorStats))
+	for name, st := range extractorStats {
+		out[name] = *st
+	}
+	return out
+}
+
+// extractorsHandler serves GET /api/extractors: the configured backend and
+// per-backend call stats.
+func extractorsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	writeJSON(w, map[string]any{
+		"backend":   entityBackend(),
+		"available": extractorBackends,
+		"breaker":   llm.status(),
+		"stats":     extractorStatsSnapshot(),
+	})
+}
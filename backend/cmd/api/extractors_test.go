@@ -0,0 +1,261 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+const stubTitle = "The Matrix 1999 1080p BluRay"
+
+// stubEntities is what a well-behaved LLM returns for stubTitle.
+const stubEntities = `{"entities": [
+    {"type": "FILM TITLE", "text": "The Matrix", "confidence": 0.9},
+    {"type": "YEAR", "text": "1999", "confidence": 0.9}
+]}`
+
+// llmStub serves one LLM endpoint. reply answers the n-th call (from 1) with
+// a status and, for 200, the raw text the model generated.
+type llmStub struct {
+	calls  atomic.Int32
+	bearer atomic.Value
+}
+
+func newOllamaStub(t *testing.T, reply func(n int) (int, string)) *llmStub {
+	return newLLMStub(t, "/api/generate", func(w http.ResponseWriter, text string) {
+		json.NewEncoder(w).Encode(OllamaResponse{Response: text})
+	}, reply, func(url string) { t.Setenv("OLLAMA_URL", url) })
+}
+
+func newOpenAIStub(t *testing.T, reply func(n int) (int, string)) *llmStub {
+	return newLLMStub(t, "/v1/chat/completions", func(w http.ResponseWriter, text string) {
+		json.NewEncoder(w).Encode(map[string]any{
+			"choices": []map[string]any{{"message": chatMessage{Role: "assistant", Content: text}}},
+		})
+	}, reply, func(url string) { t.Setenv("OPENAI_BASE_URL", url+"/v1") })
+}
+
+func newLLMStub(t *testing.T, path string, write func(http.ResponseWriter, string), reply func(n int) (int, string), configure func(url string)) *llmStub {
+	t.Helper()
+	s := &llmStub{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != path {
+			http.NotFound(w, r)
+			return
+		}
+		s.bearer.Store(r.Header.Get("Authorization"))
+		status, text := reply(int(s.calls.Add(1)))
+		if status != http.StatusOK {
+			http.Error(w, text, status)
+			return
+		}
+		write(w, text)
+	}))
+	t.Cleanup(srv.Close)
+	configure(srv.URL)
+	t.Setenv("OLLAMA_MODEL", "stub-model")
+	t.Setenv("OPENAI_MODEL", "stub-model")
+	t.Setenv("OPENAI_API_KEY", "stub-key")
+	t.Setenv("LLM_MAX_RETRIES", "2")
+	useFreshLLM(t)
+	useBuiltinPrompts(t)
+	useFreshExtractorStats(t)
+	return s
+}
+
+// useBuiltinPrompts renders the built-in prompts without loading templates
+// from the database.
+func useBuiltinPrompts(t *testing.T) {
+	t.Helper()
+	activePromptsMu.Lock()
+	prev := activePrompts
+	activePrompts = map[string]PromptTemplate{}
+	activePromptsMu.Unlock()
+	t.Cleanup(func() {
+		activePromptsMu.Lock()
+		activePrompts = prev
+		activePromptsMu.Unlock()
+	})
+}
+
+func useFreshExtractorStats(t *testing.T) {
+	t.Helper()
+	extractorStatsMu.Lock()
+	prev := extractorStats
+	extractorStats = map[string]*extractorStat{}
+	extractorStatsMu.Unlock()
+	t.Cleanup(func() {
+		extractorStatsMu.Lock()
+		extractorStats = prev
+		extractorStatsMu.Unlock()
+	})
+}
+
+func always(status int, text string) func(int) (int, string) {
+	return func(int) (int, string) { return status, text }
+}
+
+// stubBackends runs a test against both LLM backends.
+var stubBackends = []struct {
+	name    string
+	backend string
+	stub    func(*testing.T, func(int) (int, string)) *llmStub
+}{
+	{"ollama", extractorOllama, newOllamaStub},
+	{"openai", extractorOpenAI, newOpenAIStub},
+}
+
+func TestExtractGoodResponse(t *testing.T) {
+	for _, b := range stubBackends {
+		t.Run(b.name, func(t *testing.T) {
+			stub := b.stub(t, always(http.StatusOK, stubEntities))
+			resp, err := newEntityExtractor(b.backend).Extract(context.Background(), stubTitle)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if e := findEntityByType(resp.Entities, entityFilmTitle); e == nil || e.Text != "The Matrix" {
+				t.Errorf("film title = %+v, want The Matrix", e)
+			}
+			if e := findEntityByType(resp.Entities, entityYear); e == nil || e.Value == nil || *e.Value != 1999 {
+				t.Errorf("year = %+v, want 1999", e)
+			}
+			if n := stub.calls.Load(); n != 1 {
+				t.Errorf("server called %d time(s), want 1", n)
+			}
+			if b.backend == extractorOpenAI {
+				if got := stub.bearer.Load(); got != "Bearer stub-key" {
+					t.Errorf("Authorization = %q, want the API key", got)
+				}
+			}
+		})
+	}
+}
+
+func TestExtractBareArray(t *testing.T) {
+	b := stubBackends[0]
+	b.stub(t, always(http.StatusOK, `[{"type": "FILM TITLE", "text": "The Matrix"}]`))
+	resp, err := newEntityExtractor(b.backend).Extract(context.Background(), stubTitle)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Entities) != 1 || resp.Entities[0].Type != entityFilmTitle {
+		t.Errorf("entities = %+v, want the film title", resp.Entities)
+	}
+}
+
+func TestExtractMalformedJSON(t *testing.T) {
+	for _, b := range stubBackends {
+		for _, text := range []string{"not json", "   ", `{"entities": [`} {
+			t.Run(b.name, func(t *testing.T) {
+				stub := b.stub(t, always(http.StatusOK, text))
+				if _, err := newEntityExtractor(b.backend).Extract(context.Background(), stubTitle); err == nil {
+					t.Errorf("Extract(%q) succeeded, want an error", text)
+				}
+				if n := stub.calls.Load(); n != 1 {
+					t.Errorf("server called %d time(s), want 1: invalid output isn't retried", n)
+				}
+			})
+		}
+	}
+}
+
+func TestExtractRetriesTransientErrors(t *testing.T) {
+	for _, b := range stubBackends {
+		t.Run(b.name, func(t *testing.T) {
+			stub := b.stub(t, func(n int) (int, string) {
+				switch n {
+				case 1:
+					return http.StatusTooManyRequests, "slow down"
+				case 2:
+					return http.StatusServiceUnavailable, "loading"
+				}
+				return http.StatusOK, stubEntities
+			})
+			if _, err := newEntityExtractor(b.backend).Extract(context.Background(), stubTitle); err != nil {
+				t.Fatalf("Extract after 429 and 503: %v", err)
+			}
+			if n := stub.calls.Load(); n != 3 {
+				t.Errorf("server called %d time(s), want 3", n)
+			}
+		})
+	}
+}
+
+func TestExtractGivesUpAfterRetries(t *testing.T) {
+	stub := newOllamaStub(t, always(http.StatusInternalServerError, "boom"))
+	if _, err := newEntityExtractor(extractorOllama).Extract(context.Background(), stubTitle); err == nil {
+		t.Fatal("Extract succeeded, want an error")
+	}
+	if n := stub.calls.Load(); n != 3 {
+		t.Errorf("server called %d time(s), want 1 + LLM_MAX_RETRIES", n)
+	}
+}
+
+func TestExtractDoesNotRetryClientErrors(t *testing.T) {
+	for _, b := range stubBackends {
+		t.Run(b.name, func(t *testing.T) {
+			stub := b.stub(t, always(http.StatusBadRequest, "unknown model"))
+			_, err := newEntityExtractor(b.backend).Extract(context.Background(), stubTitle)
+			if err == nil || !strings.Contains(err.Error(), "400") {
+				t.Errorf("err = %v, want the 400", err)
+			}
+			if n := stub.calls.Load(); n != 1 {
+				t.Errorf("server called %d time(s), want 1", n)
+			}
+		})
+	}
+}
+
+func TestExtractWithStatsCountsPerBackend(t *testing.T) {
+	newOllamaStub(t, func(n int) (int, string) {
+		if n == 2 {
+			return http.StatusBadRequest, "bad request"
+		}
+		return http.StatusOK, stubEntities
+	})
+	ollama := newEntityExtractor(extractorOllama)
+	for i := 0; i < 3; i++ {
+		extractWithStats(context.Background(), ollama, stubTitle)
+	}
+	if _, err := extractWithStats(context.Background(), parserExtractor{}, stubTitle); err != nil {
+		t.Fatal(err)
+	}
+
+	stats := extractorStatsSnapshot()
+	if st := stats[extractorOllama]; st.Calls != 3 || st.Failures != 1 || !strings.Contains(st.LastError, "400") || st.LastErrorAt == "" {
+		t.Errorf("ollama stats = %+v, want 3 calls, 1 failure with the 400", st)
+	}
+	if st := stats[extractorParser]; st.Calls != 1 || st.Failures != 0 {
+		t.Errorf("parser stats = %+v, want 1 call, no failures", st)
+	}
+	if _, ok := stats[extractorOpenAI]; ok {
+		t.Errorf("openai has stats without being called")
+	}
+}
+
+func TestRecordExtractionLatency(t *testing.T) {
+	useFreshExtractorStats(t)
+	recordExtraction(extractorOllama, 100_000_000, nil) // 100ms
+	recordExtraction(extractorOllama, 300_000_000, nil) // 300ms
+	st := extractorStatsSnapshot()[extractorOllama]
+	if st.AvgLatencyMs != 200 || st.LastLatencyMs != 300 {
+		t.Errorf("latency avg=%v last=%v, want 200 and 300", st.AvgLatencyMs, st.LastLatencyMs)
+	}
+}
+
+func TestParserExtractor(t *testing.T) {
+	resp, err := newEntityExtractor(extractorParser).Extract(context.Background(), "The.Matrix.1999.1080p.BluRay.x264-GRP")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{entityFilmTitle: "The Matrix", entityYear: "1999", entityResolution: "1080p"}
+	for typ, text := range want {
+		if e := findEntityByType(resp.Entities, typ); e == nil || !strings.EqualFold(e.Text, text) {
+			t.Errorf("%s = %+v, want %q", typ, e, text)
+		}
+	}
+}
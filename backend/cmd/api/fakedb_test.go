@@ -0,0 +1,116 @@
+package main
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"io"
+	"sync"
+	"testing"
+)
+
+// fakeQueryFunc answers one statement sent to the fake database with the
+// columns and rows of a query; execs ignore them.
+type fakeQueryFunc func(query string, args []driver.Value) (columns []string, rows [][]driver.Value, err error)
+
+// fakeDBs maps a DSN (the test name) to the function answering its statements.
+var fakeDBs sync.Map
+
+func init() {
+	sql.Register("fakedb", fakeDriver{})
+}
+
+// useFakeDB points db at a fake database answered by handle until the test
+// ends, so code that stores to Postgres can run without one.
+func useFakeDB(t *testing.T, handle fakeQueryFunc) {
+	t.Helper()
+	fakeDBs.Store(t.Name(), handle)
+	fake, err := sql.Open("fakedb", t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	prev := db
+	db = fake
+	t.Cleanup(func() {
+		db = prev
+		fake.Close()
+		fakeDBs.Delete(t.Name())
+	})
+}
+
+// useFreshLLM gives the test its own LLM clients with no retry delay, so
+// breaker state doesn't leak between tests.
+func useFreshLLM(t *testing.T) {
+	t.Helper()
+	t.Setenv("LLM_RETRY_BACKOFF_MS", "1")
+	prevSlots, prevLLM, prevEmbedding, prevWarmup := llmSlots, llm, embeddingLLM, warmupLLM
+	llmSlots = &llmSemaphore{}
+	llm, embeddingLLM, warmupLLM = newLLMClient("extraction"), newLLMClient("embeddings"), newLLMClient("warmup")
+	t.Cleanup(func() {
+		llmSlots, llm, embeddingLLM, warmupLLM = prevSlots, prevLLM, prevEmbedding, prevWarmup
+	})
+}
+
+type fakeDriver struct{}
+
+func (fakeDriver) Open(dsn string) (driver.Conn, error) {
+	h, ok := fakeDBs.Load(dsn)
+	if !ok {
+		return nil, fmt.Errorf("no fake database %q", dsn)
+	}
+	return &fakeConn{handle: h.(fakeQueryFunc)}, nil
+}
+
+type fakeConn struct{ handle fakeQueryFunc }
+
+func (c *fakeConn) Prepare(query string) (driver.Stmt, error) {
+	return &fakeStmt{conn: c, query: query}, nil
+}
+
+func (c *fakeConn) Close() error              { return nil }
+func (c *fakeConn) Begin() (driver.Tx, error) { return fakeTx{}, nil }
+
+type fakeTx struct{}
+
+func (fakeTx) Commit() error   { return nil }
+func (fakeTx) Rollback() error { return nil }
+
+type fakeStmt struct {
+	conn  *fakeConn
+	query string
+}
+
+func (s *fakeStmt) Close() error  { return nil }
+func (s *fakeStmt) NumInput() int { return -1 }
+
+func (s *fakeStmt) Exec(args []driver.Value) (driver.Result, error) {
+	if _, _, err := s.conn.handle(s.query, args); err != nil {
+		return nil, err
+	}
+	return driver.RowsAffected(1), nil
+}
+
+func (s *fakeStmt) Query(args []driver.Value) (driver.Rows, error) {
+	columns, rows, err := s.conn.handle(s.query, args)
+	if err != nil {
+		return nil, err
+	}
+	return &fakeRows{columns: columns, rows: rows}, nil
+}
+
+type fakeRows struct {
+	columns []string
+	rows    [][]driver.Value
+}
+
+func (r *fakeRows) Columns() []string { return r.columns }
+func (r *fakeRows) Close() error      { return nil }
+
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if len(r.rows) == 0 {
+		return io.EOF
+	}
+	copy(dest, r.rows[0])
+	r.rows = r.rows[1:]
+	return nil
+}
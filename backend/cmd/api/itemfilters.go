@@ -0,0 +1,91 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// itemFilters holds an item's compiled keyword and regex filters. Terms are
+// compared on normalized whole words, so "extras" matches "Movie.Extras.1080p"
+// but not "Extraspecial".
+type itemFilters struct {
+	mustContain    []string
+	mustNotContain []string
+	include        []*regexp.Regexp
+	exclude        []*regexp.Regexp
+}
+
+// compileItemFilters prepares an item's filters for matching. Regex filters
+// prefixed with "!" must NOT match the title; all others must match.
+func compileItemFilters(it Item) (*itemFilters, error) {
+	f := &itemFilters{}
+	for _, t := range it.MustContain {
+		if n := normalize(t); n != "" {
+			f.mustContain = append(f.mustContain, n)
+		}
+	}
+	for _, t := range it.MustNotContain {
+		if n := normalize(t); n != "" {
+			f.mustNotContain = append(f.mustNotContain, n)
+		}
+	}
+	for _, pattern := range it.RegexFilters {
+		negate := strings.HasPrefix(pattern, "!")
+		re, err := regexp.Compile(strings.TrimPrefix(pattern, "!"))
+		if err != nil {
+			return nil, fmt.Errorf("regex filter %q: %w", pattern, err)
+		}
+		if negate {
+			f.exclude = append(f.exclude, re)
+		} else {
+			f.include = append(f.include, re)
+		}
+	}
+	return f, nil
+}
+
+func (f *itemFilters) empty() bool {
+	return len(f.mustContain) == 0 && len(f.mustNotContain) == 0 && len(f.include) == 0 && len(f.exclude) == 0
+}
+
+// Reject returns a description of the first filter that rejects the title, or
+// "" when the title passes all of them.
+func (f *itemFilters) Reject(title string) string {
+	words := " " + normalize(title) + " "
+	for _, t := range f.mustContain {
+		if !strings.Contains(words, " "+t+" ") {
+			return fmt.Sprintf("must_contain %q", t)
+		}
+	}
+	for _, t := range f.mustNotContain {
+		if strings.Contains(words, " "+t+" ") {
+			return fmt.Sprintf("must_not_contain %q", t)
+		}
+	}
+	for _, re := range f.include {
+		if !re.MatchString(title) {
+			return fmt.Sprintf("regex %q", re.String())
+		}
+	}
+	for _, re := range f.exclude {
+		if re.MatchString(title) {
+			return fmt.Sprintf("regex %q", "!"+re.String())
+		}
+	}
+	return ""
+}
+
+// formList returns the non-empty trimmed values of a repeated form field.
+// Sending the field once with an empty value clears the list.
+func formList(values []string) []string {
+	out := []string{}
+	for _, v := range values {
+		for _, line := range strings.Split(v, "\n") {
+			if line = strings.TrimSpace(line); line != "" {
+				out = append(out, line)
+			}
+		}
+	}
+	return out
+}
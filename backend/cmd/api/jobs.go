@@ -0,0 +1,954 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/playwright-community/playwright-go"
+)
+
+// searchJobPayload is fixed when the run is planned: like the original
+// in-memory loop, the match limit and the episodes to look for don't change
+// while the run goes on.
+type searchJobPayload struct {
+	URLID      int64        `json:"url_id"`
+	MaxMatches int          `json:"max_matches"`
+	SearchAll  bool         `json:"search_all"`
+	Episodes   []episodeKey `json:"episodes,omitempty"` // series only
+}
+
+type rankJobPayload struct {
+	MaxMatches int  `json:"max_matches"`
+	SearchAll  bool `json:"search_all"`
+}
+
+// searchJobResult is stored with a finished search or rank job.
+type searchJobResult struct {
+	Accepted   int               `json:"accepted"`
+	Skipped    string            `json:"skipped,omitempty"`
+	Candidates []queuedCandidate `json:"candidates,omitempty"` // SEARCH_ALL_SITES: ranked by the rank job
+}
+
+// matchNotification is what the notify job sends for a new match.
+type matchNotification struct {
+	MatchID         int64          `json:"match_id"`
+	Item            string         `json:"item"`
+	Title           string         `json:"title"`
+	URL             string         `json:"url"`
+	Site            string         `json:"site"`
+	Upgrade         bool           `json:"upgrade,omitempty"`
+	PreviousQuality string         `json:"previous_quality,omitempty"`
+	Quality         string         `json:"quality,omitempty"`
+	Payload         map[string]any `json:"payload"` // WebSocket message
+}
+
+type magnetJobPayload struct {
+	MatchID int64             `json:"match_id"`
+	URL     string            `json:"url"`
+	Notify  matchNotification `json:"notify"`
+}
+
+// queuedCandidate is a confirmedMatch stored in a job result until the rank
+// job ranks it, with its trace so far.
+type queuedCandidate struct {
+	Site         string           `json:"site"`
+	Query        string           `json:"query"`
+	Stages       []candidateStage `json:"stages"`
+	Alias        string           `json:"alias,omitempty"`
+	Title        string           `json:"title"`
+	URL          string           `json:"url"`
+	MagnetLink   string           `json:"magnet_link,omitempty"`
+	Episode      episodeKey       `json:"episode"`
+	EntitiesJSON json.RawMessage  `json:"entities"`
+	FileSize     string           `json:"file_size,omitempty"`
+	Seeds        string           `json:"seeds,omitempty"`
+	Leechers     string           `json:"leechers,omitempty"`
+	Score        scoreBreakdown   `json:"score"`
+}
+
+func queueCandidate(c confirmedMatch) queuedCandidate {
+	return queuedCandidate{
+		Site: c.site, Query: c.trace.query, Stages: c.trace.stages, Alias: c.alias,
+		Title: c.result.Title, URL: c.result.URL, MagnetLink: c.result.MagnetLink, Episode: c.episode, EntitiesJSON: c.entitiesJSON,
+		FileSize: c.fileSize, Seeds: c.seeds, Leechers: c.leechers, Score: c.score,
+	}
+}
+
+func (q queuedCandidate) confirmed(runID, itemID int64) confirmedMatch {
+	r := SearchResult{Title: q.Title, URL: q.URL, MagnetLink: q.MagnetLink}
+	trace := newCandidateTrace(runID, itemID, q.Site, q.Query, r)
+	trace.stages = q.Stages
+	total := q.Score.Total
+	trace.score = &total
+	return confirmedMatch{
+		site: q.Site, trace: trace, alias: q.Alias, result: r, release: parseReleaseName(q.Title), episode: q.Episode,
+		entitiesJSON: q.EntitiesJSON, fileSize: q.FileSize, seeds: q.Seeds, leechers: q.Leechers, score: q.Score,
+	}
+}
+
+// itemRun is the per-item state of a run. Every job rebuilds it from the
+// database, so matches found by earlier jobs (a better best match, filled
+// episodes, a reached cutoff) are taken into account.
+type itemRun struct {
+	item            Item
+	profile         *QualityProfile
+	filters         *itemFilters
+	softDeleted     map[string]bool
+	names           []string
+	itemYear        string
+	upgradeMode     bool
+	bestMatch       ReleaseInfo
+	hasBestMatch    bool
+	missingEpisodes map[episodeKey]bool
+}
+
+// loadItemRun builds the state of an item, or returns why it is skipped.
+// verbose logs the item's settings, once when the run is planned.
+func (w *jobRunner) loadItemRun(it Item, verbose bool) (*itemRun, string, error) {
+	ir := &itemRun{item: it, profile: profileForItem(it, w.profiles, w.defaultProfile)}
+
+	if it.Satisfied {
+		return nil, fmt.Sprintf("satisfied (cutoff %s reached)", it.CutoffQuality), nil
+	}
+
+	// Upgrade mode: once an item has a match, only look for releases that
+	// rank higher than the best one until the cutoff resolution is reached.
+	ir.upgradeMode = it.CutoffQuality != "" && it.ItemType != itemTypeSeries
+	if ir.upgradeMode {
+		var err error
+		ir.bestMatch, ir.hasBestMatch, err = bestExistingMatch(it.ID)
+		if err != nil {
+			return nil, "", fmt.Errorf("load best match: %w", err)
+		}
+		if ir.hasBestMatch && cutoffReached(it.CutoffQuality, ir.bestMatch) {
+			log.Printf("CUTOFF_REACHED item=%q best=%s cutoff=%s - marking satisfied\n", it.Text, qualityLabel(ir.bestMatch), it.CutoffQuality)
+			if err := markItemSatisfied(it.ID); err != nil {
+				log.Printf("Failed to mark item %q satisfied: %v\n", it.Text, err)
+			}
+			return nil, "cutoff " + it.CutoffQuality + " reached", nil
+		}
+		if ir.hasBestMatch && verbose {
+			log.Printf("UPGRADE_MODE item=%q best=%s cutoff=%s\n", it.Text, qualityLabel(ir.bestMatch), it.CutoffQuality)
+		}
+	}
+
+	filters, err := compileItemFilters(it)
+	if err != nil {
+		log.Printf("Ignoring invalid filters for item %q: %v\n", it.Text, err)
+		filters = &itemFilters{}
+	}
+	ir.filters = filters
+	if !filters.empty() && verbose {
+		log.Printf("Item %q filters: must_contain=%v must_not_contain=%v regex=%v\n",
+			it.Text, it.MustContain, it.MustNotContain, it.RegexFilters)
+	}
+
+	// Load soft-deleted URLs for this item to skip them
+	ir.softDeleted, err = loadSoftDeletedURLs(it.ID)
+	if err != nil {
+		log.Printf("Failed to load soft-deleted URLs for item %q: %v\n", it.Text, err)
+		ir.softDeleted = make(map[string]bool) // Continue with empty map
+	}
+	if len(ir.softDeleted) > 0 && verbose {
+		log.Printf("Loaded %d soft-deleted URLs for item %q\n", len(ir.softDeleted), it.Text)
+	}
+
+	// The item's text and each alias are searched on every site
+	ir.names = itemNames(it)
+	ir.itemYear = extractYear(it.Text)
+	if len(ir.names) > 1 && verbose {
+		log.Printf("Item %q aliases: %v\n", it.Text, ir.names[1:])
+	}
+
+	if it.ItemType == itemTypeSeries {
+		missing, err := loadMissingEpisodes(it.ID)
+		if err != nil {
+			return nil, "", fmt.Errorf("load missing episodes: %w", err)
+		}
+		if len(missing) == 0 {
+			return nil, "no missing episodes", nil
+		}
+		ir.missingEpisodes = make(map[episodeKey]bool, len(missing))
+		for _, k := range missing {
+			ir.missingEpisodes[k] = true
+		}
+	}
+	return ir, "", nil
+}
+
+// enqueueRun plans a run: one search job per item and site of plan, in site
+// priority order, followed by the item's rank job.
+func (w *jobRunner) enqueueRun(runID int64, plan runPlan) (int, error) {
+	items, err := loadItems()
+	if err != nil {
+		return 0, fmt.Errorf("load items: %w", err)
+	}
+	urls, err := loadUrls()
+	if err != nil {
+		return 0, fmt.Errorf("load urls: %w", err)
+	}
+	if len(items) == 0 || len(urls) == 0 {
+		log.Println("worker: no items or no urls; done")
+		return 0, nil
+	}
+
+	jobs := 0
+	for _, it := range items {
+		if !plan.includes(it.ID) {
+			continue
+		}
+		ir, skip, err := w.loadItemRun(it, true)
+		if err != nil {
+			log.Printf("Failed to plan item %q: %v\n", it.Text, err)
+			continue
+		}
+		if skip != "" {
+			log.Printf("Item %q skipped: %s\n", it.Text, skip)
+			continue
+		}
+
+		// Movies are searched by their text; series search for the next
+		// missing episodes, one query per episode on every site.
+		maxMatches, searchAll := matchLimitsFor(it)
+		var episodes []episodeKey
+		if it.ItemType == itemTypeSeries {
+			missing, err := loadMissingEpisodes(it.ID)
+			if err != nil {
+				return jobs, fmt.Errorf("load missing episodes of %q: %w", it.Text, err)
+			}
+			if it.MaxMatches == nil {
+				maxMatches = len(missing)
+			}
+			perRun := getenvInt("SERIES_EPISODES_PER_RUN", 5)
+			if perRun > 0 && len(missing) > perRun {
+				missing = missing[:perRun]
+			}
+			log.Printf("Series %q: searching for %v (%d missing in total)\n", it.Text, missing, len(ir.missingEpisodes))
+			episodes = missing
+		}
+
+		for _, u := range urls {
+			if !plan.site(it.ID, u.ID) {
+				continue
+			}
+			p := searchJobPayload{URLID: u.ID, MaxMatches: maxMatches, SearchAll: searchAll, Episodes: episodes}
+			if err := enqueueJob(runID, jobSearch, it.ID, p); err != nil {
+				return jobs, err
+			}
+			jobs++
+		}
+		if err := enqueueJob(runID, jobRank, it.ID, rankJobPayload{MaxMatches: maxMatches, SearchAll: searchAll}); err != nil {
+			return jobs, err
+		}
+		jobs++
+	}
+	return jobs, nil
+}
+
+// jobRunner executes jobs. It holds what the jobs of one drain of the queue
+// share: Playwright, matching settings and per-run stats.
+type jobRunner struct {
+	disablePW      bool
+	pw             *playwright.Playwright // nil when DISABLE_PLAYWRIGHT is set
+	matcher        titleMatcher
+	profiles       map[int64]*QualityProfile
+	defaultProfile *QualityProfile
+	blocked        *blockRules
+	stats          map[int64]*workerRunStats
+}
+
+// newJobRunner loads the matching settings and starts Playwright. If
+// Playwright can't be started the error is returned before any job runs, so
+// the run fails once instead of every job burning its attempts.
+func newJobRunner() (*jobRunner, error) {
+	w := &jobRunner{
+		disablePW: strings.ToLower(os.Getenv("DISABLE_PLAYWRIGHT")) == "true",
+		matcher:   currentTitleMatcher(),
+		stats:     map[int64]*workerRunStats{},
+	}
+	var err error
+	if w.profiles, w.defaultProfile, err = loadQualityProfiles(); err != nil {
+		return nil, fmt.Errorf("load quality profiles: %w", err)
+	}
+	if w.blocked, err = loadBlockRules(); err != nil {
+		return nil, fmt.Errorf("load block rules: %w", err)
+	}
+	if !w.disablePW {
+		if w.pw, err = startPlaywright(); err != nil {
+			return nil, fmt.Errorf("start playwright: %w", err)
+		}
+	}
+	return w, nil
+}
+
+// runStats returns the stats of a run, loading them on the first job of a
+// run this runner works on (e.g. one resumed after a restart). Jobs look
+// their run up in w.stats once drain has loaded it.
+func (w *jobRunner) runStats(runID int64) (*workerRunStats, error) {
+	s := w.stats[runID]
+	if s == nil {
+		var err error
+		if s, err = loadRun(runID); err != nil {
+			return nil, fmt.Errorf("load run %d: %w", runID, err)
+		}
+		if s.SiteTimings == nil {
+			s.SiteTimings = map[string]*siteTiming{}
+		}
+		// A finished run is reopened by its requeued dead jobs
+		if s.Status != runFailed {
+			s.Status, s.FinishedAt = runRunning, nil
+		}
+		w.stats[runID] = s
+	}
+	return s, nil
+}
+
+func startPlaywright() (*playwright.Playwright, error) {
+	// Skip installation if browsers are pre-installed (e.g., in Docker)
+	skipInstall := os.Getenv("PLAYWRIGHT_SKIP_INSTALL") == "1"
+	if !skipInstall {
+		if err := playwright.Install(); err != nil {
+			// In container builds we already install browsers; this is a fallback.
+			log.Println("playwright.Install warning:", err)
+		}
+	}
+	pw, err := playwright.Run()
+	if err != nil {
+		log.Println("playwright.Run error:", err)
+	}
+	return pw, err
+}
+
+func (w *jobRunner) close() {
+	if w.pw != nil {
+		_ = w.pw.Stop()
+	}
+}
+
+// drain runs ready jobs until there are none left.
+func (w *jobRunner) drain() {
+	for {
+		j, err := claimJob()
+		if err != nil {
+			log.Println("claim job:", err)
+			return
+		}
+		if j == nil {
+			return
+		}
+		stats, err := w.runStats(j.RunID)
+		if err != nil {
+			// Without its run the job's stats can't be kept; retry it later
+			log.Println("worker:", err)
+			failJob(j, err)
+			continue
+		}
+		result, err := w.run(j)
+		if err != nil {
+			if !errors.Is(err, errJobNotReady) {
+				stats.recordError(fmt.Errorf("%s job %d: %w", j.Kind, j.ID, err))
+			}
+			failJob(j, err)
+		} else {
+			completeJob(j, result)
+		}
+		saveRun(stats)
+	}
+}
+
+func (w *jobRunner) run(j *queueJob) (any, error) {
+	switch j.Kind {
+	case jobSearch:
+		return w.runSearch(j)
+	case jobRank:
+		return w.runRank(j)
+	case jobMagnet:
+		return nil, w.runMagnet(j)
+	case jobNotify:
+		return nil, w.runNotify(j)
+	}
+	return nil, fmt.Errorf("unknown job kind %q", j.Kind)
+}
+
+// matchesInRun counts the visible matches a run has stored for an item. It
+// is read from the matches themselves so that a job re-run after a crash
+// counts what it inserted before.
+func matchesInRun(runID, itemID int64) (int, error) {
+	var n int
+	err := db.QueryRow(`
+        SELECT COUNT(*) FROM matches WHERE run_id = $1 AND item_id = $2 AND soft_delete = FALSE
+    `, runID, itemID).Scan(&n)
+	return n, err
+}
+
+// jobItem loads the item of a job; nil if it has been deleted since.
+func jobItem(j *queueJob) (*Item, error) {
+	if j.ItemID == nil {
+		return nil, fmt.Errorf("job %d has no item", j.ID)
+	}
+	it, err := loadItem(*j.ItemID)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &it, nil
+}
+
+// runSearch searches one site for an item: every name (text and aliases)
+// and, for series, every wanted episode.
+func (w *jobRunner) runSearch(j *queueJob) (any, error) {
+	var p searchJobPayload
+	if err := json.Unmarshal(j.Payload, &p); err != nil {
+		return nil, err
+	}
+	it, err := jobItem(j)
+	if err != nil || it == nil {
+		return searchJobResult{Skipped: "item deleted"}, err
+	}
+	u, err := loadURL(p.URLID)
+	if err == sql.ErrNoRows {
+		return searchJobResult{Skipped: "site deleted"}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	ir, skip, err := w.loadItemRun(*it, false)
+	if err != nil {
+		return nil, err
+	}
+	if skip != "" {
+		return searchJobResult{Skipped: skip}, nil
+	}
+	found, err := matchesInRun(j.RunID, it.ID)
+	if err != nil {
+		return nil, err
+	}
+	if !p.SearchAll && found >= p.MaxMatches {
+		log.Printf("Found %d matches for item %q, skipping %s\n", found, it.Text, u.URL)
+		return searchJobResult{Skipped: "match limit reached"}, nil
+	}
+
+	s := newGenericScraper(u)
+
+	var tasks []searchTask
+	if it.ItemType == itemTypeSeries {
+		for i := range p.Episodes {
+			for _, name := range ir.names {
+				tasks = append(tasks, searchTask{scraper: s, query: newSearchQuery(name, ir.itemYear, &p.Episodes[i]), episode: &p.Episodes[i]})
+			}
+		}
+	} else {
+		for _, name := range ir.names {
+			tasks = append(tasks, searchTask{scraper: s, query: newSearchQuery(name, ir.itemYear, nil)})
+		}
+	}
+
+	res := searchJobResult{}
+	seenResults := map[string]bool{} // URL, so alias queries don't evaluate a result twice
+	var searchErr error
+	searched := 0
+	for _, task := range tasks {
+		// Check if we've already found enough matches for this item
+		if !p.SearchAll && found+res.Accepted >= p.MaxMatches {
+			log.Printf("Found %d matches for item %q, moving to next item\n", found+res.Accepted, it.Text)
+			break
+		}
+		// Move on once the episode this query was for has been found
+		if task.episode != nil && !episodeWanted(ir.missingEpisodes, *task.episode) {
+			continue
+		}
+
+		began := time.Now()
+		results, queries, err := searchVariants(context.Background(), s, w.pw, task.query)
+		w.stats[j.RunID].recordSearch(s.Name(), time.Since(began), len(results), err)
+		if err != nil {
+			log.Printf("scraper %s error: %v\n", s.Name(), err)
+			searchErr = err
+			continue
+		}
+		searched++
+		log.Printf("Scraper %s returned %d results for queries %q (item %q)\n", s.Name(), len(results), queries, it.Text)
+
+		confirmed := w.evaluateResults(j.RunID, ir, s, strings.Join(queries, " | "), results, seenResults)
+		if p.SearchAll {
+			// Ranked together by the rank job once every site has been searched
+			for _, c := range confirmed {
+				res.Candidates = append(res.Candidates, queueCandidate(c))
+			}
+			continue
+		}
+		accepted, stop := w.acceptRanked(j.RunID, ir, confirmed, p.MaxMatches, found+res.Accepted)
+		res.Accepted += accepted
+		if stop {
+			break
+		}
+	}
+	// Retry the site if no query could be searched at all
+	if searched == 0 && searchErr != nil {
+		return nil, searchErr
+	}
+	return res, nil
+}
+
+// evaluateResults runs the results of one search through the filters and
+// matchers and returns the confirmed, scored candidates.
+func (w *jobRunner) evaluateResults(runID int64, ir *itemRun, s SiteScraper, query string, results []SearchResult, seenResults map[string]bool) []confirmedMatch {
+	it := ir.item
+	stats := w.stats[runID]
+
+	var passed []preFilteredResult
+	for i, r := range results {
+		log.Printf("  Result %d: title=%q url=%s has_magnet=%v\n", i+1, r.Title, r.URL, r.MagnetLink != "")
+		if seenResults[r.URL] {
+			log.Printf("DUPLICATE_RESULT site=%s url=%s - already evaluated for this item\n", s.Name(), r.URL)
+			continue
+		}
+		seenResults[r.URL] = true
+		stats.CandidatesSeen++
+		trace := newCandidateTrace(runID, it.ID, s.Name(), query, r)
+
+		// Check if this URL was previously soft-deleted for this item
+		if ir.softDeleted[r.URL] {
+			log.Printf("SOFT_DELETED_SKIP site=%s url=%s title=%q - previously hidden by user\n", s.Name(), r.URL, r.Title)
+			trace.reject("hidden_by_user", "previously hidden by user")
+			continue
+		}
+
+		// Apply the item's keyword/regex filters
+		if rejectedBy := ir.filters.Reject(r.Title); rejectedBy != "" {
+			log.Printf("ITEM_FILTER_REJECTED filter=%s site=%s url=%s title=%q - skipping\n", rejectedBy, s.Name(), r.URL, r.Title)
+			trace.reject("item_filter", rejectedBy)
+			continue
+		}
+		if !ir.filters.empty() {
+			trace.stage("item_filter", true, "")
+		}
+
+		// Check quality FIRST before any other processing
+		release := parseReleaseName(r.Title)
+
+		// Accepted block rules apply to every item
+		if rule := w.blocked.Reject(s.Name(), r.Title, release); rule != "" {
+			log.Printf("BLOCK_RULE_REJECTED rule=%s site=%s url=%s title=%q - skipping\n", rule, s.Name(), r.URL, r.Title)
+			trace.reject("block_rule", rule)
+			continue
+		}
+		if ir.profile != nil {
+			if rej := ir.profile.Check(r.Title, release, it.RuntimeMinutes); rej != nil {
+				log.Printf("QUALITY_REJECTED profile=%q rule=%s (%s) site=%s url=%s title=%q - skipping\n",
+					ir.profile.Name, rej.Rule, rej.Detail, s.Name(), r.URL, r.Title)
+				trace.reject("quality", fmt.Sprintf("profile %q rule %s (%s)", ir.profile.Name, rej.Rule, rej.Detail))
+				continue
+			}
+			trace.stage("quality", true, qualityLabel(release))
+		}
+
+		if ir.upgradeMode && ir.hasBestMatch && qualityRank(release) <= qualityRank(ir.bestMatch) {
+			log.Printf("UPGRADE_NOT_BETTER candidate=%s best=%s site=%s url=%s title=%q - skipping\n",
+				qualityLabel(release), qualityLabel(ir.bestMatch), s.Name(), r.URL, r.Title)
+			trace.reject("upgrade", fmt.Sprintf("%s is not better than %s", qualityLabel(release), qualityLabel(ir.bestMatch)))
+			continue
+		}
+
+		// Series only accept releases for episodes that are still missing
+		var releaseEpisode episodeKey
+		if it.ItemType == itemTypeSeries {
+			key, ok := episodeKeyFor(release)
+			if !ok {
+				log.Printf("NO_EPISODE_INFO site=%s url=%s title=%q - skipping\n", s.Name(), r.URL, r.Title)
+				trace.reject("episode", "no season/episode in title")
+				continue
+			}
+			if !episodeWanted(ir.missingEpisodes, key) {
+				log.Printf("EPISODE_NOT_WANTED episode=%s site=%s url=%s title=%q - skipping\n", key, s.Name(), r.URL, r.Title)
+				trace.reject("episode", key.String()+" not wanted")
+				continue
+			}
+			trace.stage("episode", true, key.String())
+			releaseEpisode = key
+		}
+
+		// Log the scraped torrent title before processing
+		log.Printf("Scraped from page: title=%q url=%s\n", r.Title, r.URL)
+
+		// Pre-filter: the item title or one of its aliases must appear as a
+		// contiguous phrase in the result before we spend time on matching
+		var candidateNames []string
+		for _, name := range ir.names {
+			phrase, ok := preFilterPhrase(name, r.Title)
+			if ok {
+				log.Printf("PRE_FILTER_PASSED: item phrase %q found in title - proceeding to LLM\n", phrase)
+				candidateNames = append(candidateNames, name)
+			}
+		}
+		if len(candidateNames) == 0 {
+			log.Printf("PRE_FILTER_REJECTED: no phrase of %q found contiguously in title %q - skipping LLM\n",
+				ir.names, normalize(r.Title))
+			trace.reject("pre_filter", fmt.Sprintf("none of %q found in %q", ir.names, normalize(r.Title)))
+			continue
+		}
+		trace.stage("pre_filter", true, strings.Join(candidateNames, ", "))
+		passed = append(passed, preFilteredResult{
+			result:  r,
+			trace:   trace,
+			release: release,
+			episode: releaseEpisode,
+			names:   candidateNames,
+		})
+	}
+
+	// Titles the release parser can't handle go to the LLM in batches
+	useEntityMatching := strings.ToLower(os.Getenv("USE_ENTITY_MATCHING")) == "true"
+	var batch entityBatch
+	if useEntityMatching && len(passed) > 0 {
+		titles := make([]string, len(passed))
+		for i, pf := range passed {
+			titles[i] = pf.result.Title
+		}
+		batch = prefetchEntities(context.Background(), titles, stats)
+	}
+
+	var confirmed []confirmedMatch
+	for _, pf := range passed {
+		r, trace, release, releaseEpisode, candidateNames := pf.result, pf.trace, pf.release, pf.episode, pf.names
+
+		// Extract entities from torrent title (release parser first, LLM as fallback)
+		var entitiesJSON []byte = []byte("[]") // Initialize to empty JSON array
+		var entities []Entity
+
+		if useEntityMatching {
+			entityResp, source, err := batch.extract(context.Background(), r.Title, stats)
+			if err != nil {
+				log.Printf("Entity extraction failed for %q: %v\n", r.Title, err)
+				// Fall back to fuzzy matching if entity extraction fails
+			} else {
+				entities = entityResp.Entities
+				entitiesJSON, _ = json.Marshal(entities)
+				log.Printf("Extracted %d entities from %q via %s (URL: %s):\n", len(entities), r.Title, source, r.URL)
+				for i, entity := range entities {
+					log.Printf("  [%d] Type: %-20s Text: %-30s Confidence: %.2f\n",
+						i+1, entity.Type, entity.Text, entity.Confidence)
+				}
+			}
+		}
+
+		// Accept the result if it matches the title or any alias
+		matchedName := matchNames(candidateNames, ir.itemYear, r.Title, entities, useEntityMatching, w.matcher, trace)
+		if matchedName == "" {
+			trace.finish(decisionRejected, "no match", 0)
+			continue
+		}
+		matchedAlias := ""
+		if matchedName != it.Text {
+			matchedAlias = matchedName
+			log.Printf("ALIAS_MATCH item=%q alias=%q title=%q\n", it.Text, matchedAlias, r.Title)
+		}
+
+		// Match confirmed! Score it now; the best-ranked candidates from this
+		// page are inserted once all results have been evaluated.
+		fileSize, seeds, leechers := entityStats(entities)
+		breakdown := scoreMatch(release, ir.profile, seeds, leechers, fileSize, scraperTrust(s))
+		trace.score = &breakdown.Total
+		log.Printf("MATCH_SCORED score=%.3f quality=%.2f seeds=%.2f size=%.2f trust=%.2f title=%q\n",
+			breakdown.Total, breakdown.Quality, breakdown.Seeds, breakdown.Size, breakdown.SiteTrust, r.Title)
+		confirmed = append(confirmed, confirmedMatch{
+			site:         s.Name(),
+			trace:        trace,
+			alias:        matchedAlias,
+			result:       r,
+			release:      release,
+			episode:      releaseEpisode,
+			entitiesJSON: entitiesJSON,
+			fileSize:     fileSize,
+			seeds:        seeds,
+			leechers:     leechers,
+			score:        breakdown,
+		})
+	}
+	return confirmed
+}
+
+// acceptRanked inserts confirmed candidates best-first until the item's
+// match limit is reached, so the cap keeps the best results rather than the
+// first ones found. Each new match gets a magnet job, which queues its
+// notification. stop is set once the item needs no more searching.
+func (w *jobRunner) acceptRanked(runID int64, ir *itemRun, confirmed []confirmedMatch, maxMatches, found int) (accepted int, stop bool) {
+	it := ir.item
+	sort.SliceStable(confirmed, func(i, j int) bool {
+		return confirmed[i].score.Total > confirmed[j].score.Total
+	})
+	for rank, c := range confirmed {
+		if stop || found+accepted >= maxMatches {
+			c.trace.stage("rank", false, fmt.Sprintf("rank %d, limit %d reached", rank+1, maxMatches))
+			c.trace.finish(decisionNotKept, "match limit reached", 0)
+			continue
+		}
+		c.trace.stage("rank", true, fmt.Sprintf("rank %d of %d", rank+1, len(confirmed)))
+		r, release, releaseEpisode := c.result, c.release, c.episode
+
+		// An earlier, higher-ranked candidate may already have covered these
+		if it.ItemType == itemTypeSeries && !episodeWanted(ir.missingEpisodes, releaseEpisode) {
+			log.Printf("EPISODE_ALREADY_FOUND episode=%s title=%q - skipping\n", releaseEpisode, r.Title)
+			c.trace.finish(decisionNotKept, "episode already found", 0)
+			continue
+		}
+		if ir.upgradeMode && ir.hasBestMatch && qualityRank(release) <= qualityRank(ir.bestMatch) {
+			log.Printf("UPGRADE_NOT_BETTER candidate=%s best=%s title=%q - skipping\n", qualityLabel(release), qualityLabel(ir.bestMatch), r.Title)
+			c.trace.finish(decisionNotKept, "not better than the "+qualityLabel(ir.bestMatch)+" match", 0)
+			continue
+		}
+
+		// The magnet link is extracted from the detail page by a magnet job
+		log.Printf(">>> MATCH CONFIRMED for %q, queueing magnet link extraction from %s\n", r.Title, r.URL)
+		matchID, inserted, err := insertMatchWithEntities(runID, it.ID, r.Title, r.URL, c.site, r.Title, "", c.alias, c.entitiesJSON, c.score)
+		if err != nil {
+			log.Printf("insert match error: %v\n", err)
+			c.trace.finish(decisionNotKept, "insert failed: "+err.Error(), 0)
+			continue
+		}
+		if !inserted {
+			c.trace.finish(decisionDuplicate, "already stored for this item", 0)
+			continue
+		}
+		w.stats[runID].MatchesInserted++
+		// Check if this match has zero seeds - if so, it was auto soft-deleted
+		if c.seeds == "0" {
+			log.Printf("ZERO_SEEDS_AUTO_SOFT_DELETE site=%s item=%q title=%q url=%s seeds=%s - match inserted but soft-deleted, not counted\n",
+				c.site, it.Text, r.Title, r.URL, c.seeds)
+			c.trace.finish(decisionAutoHidden, "zero seeds", matchID)
+			// Don't count, don't notify
+			continue
+		}
+		c.trace.finish(decisionMatched, "", matchID)
+
+		if it.ItemType == itemTypeSeries {
+			filled, err := recordEpisodeMatch(it.ID, matchID, releaseEpisode)
+			if err != nil {
+				log.Printf("record episode match error: %v\n", err)
+			}
+			for _, k := range filled {
+				delete(ir.missingEpisodes, k)
+			}
+			log.Printf("EPISODE_FOUND item=%q release=%s filled=%v (%d still missing)\n", it.Text, releaseEpisode, filled, len(ir.missingEpisodes))
+		}
+
+		accepted++
+		log.Printf("MATCH site=%s item=%q title=%q url=%s seeds=%s score=%.3f (match %d/%d)\n",
+			c.site, it.Text, r.Title, r.URL, c.seeds, c.score.Total, found+accepted, maxMatches)
+
+		n := matchNotification{
+			MatchID: matchID,
+			Item:    it.Text,
+			Title:   r.Title,
+			URL:     r.URL,
+			Site:    c.site,
+			Payload: map[string]any{
+				"id":           matchID,
+				"item":         it.Text,
+				"url":          r.URL,
+				"site":         c.site,
+				"torrent_text": r.Title,
+				"file_size":    c.fileSize,
+				"seeds":        c.seeds,
+				"leechers":     c.leechers,
+				"score":        c.score.Total,
+				"alias":        c.alias,
+				"run_id":       runID,
+				"created":      time.Now().Format(time.RFC3339),
+			},
+		}
+		if ir.upgradeMode && ir.hasBestMatch {
+			// Upgrades get their own notification type
+			log.Printf("MATCH_UPGRADE item=%q %s -> %s\n", it.Text, qualityLabel(ir.bestMatch), qualityLabel(release))
+			n.Upgrade, n.PreviousQuality, n.Quality = true, qualityLabel(ir.bestMatch), qualityLabel(release)
+			n.Payload["previous_quality"] = n.PreviousQuality
+			n.Payload["quality"] = n.Quality
+		}
+		if err := enqueueJob(runID, jobMagnet, it.ID, magnetJobPayload{MatchID: matchID, URL: r.URL, Notify: n}); err != nil {
+			log.Printf("Failed to queue magnet extraction for match %d: %v\n", matchID, err)
+		}
+
+		if ir.upgradeMode {
+			ir.bestMatch, ir.hasBestMatch = release, true
+			if cutoffReached(it.CutoffQuality, release) {
+				log.Printf("CUTOFF_REACHED item=%q quality=%s cutoff=%s - marking satisfied\n", it.Text, qualityLabel(release), it.CutoffQuality)
+				if err := markItemSatisfied(it.ID); err != nil {
+					log.Printf("Failed to mark item %q satisfied: %v\n", it.Text, err)
+				}
+				stop = true // stop searching for this item
+				continue
+			}
+		}
+
+		// Check if we've reached the limit after inserting
+		if found+accepted >= maxMatches {
+			log.Printf("Reached %d matches for item %q, moving to next item\n", found+accepted, it.Text)
+		}
+	}
+	return accepted, stop || found+accepted >= maxMatches
+}
+
+// runRank waits for the item's searches, ranks their candidates when every
+// site is searched before the limit applies (SEARCH_ALL_SITES) and logs the
+// item's completion.
+func (w *jobRunner) runRank(j *queueJob) (any, error) {
+	var p rankJobPayload
+	if err := json.Unmarshal(j.Payload, &p); err != nil {
+		return nil, err
+	}
+	it, err := jobItem(j)
+	if err != nil || it == nil {
+		return searchJobResult{Skipped: "item deleted"}, err
+	}
+
+	var searching int
+	if err := db.QueryRow(`
+        SELECT COUNT(*) FROM jobs WHERE run_id = $1 AND item_id = $2 AND kind = $3 AND status IN ($4, $5)
+    `, j.RunID, it.ID, jobSearch, jobPending, jobRunning).Scan(&searching); err != nil {
+		return nil, err
+	}
+	if searching > 0 {
+		return nil, errJobNotReady
+	}
+
+	// Matches stored before a crash are counted, not inserted again
+	found, err := matchesInRun(j.RunID, it.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	res := searchJobResult{}
+	if p.SearchAll {
+		rows, err := db.Query(`
+            SELECT result FROM jobs WHERE run_id = $1 AND item_id = $2 AND kind = $3 AND status = $4 AND result IS NOT NULL
+        `, j.RunID, it.ID, jobSearch, jobDone)
+		if err != nil {
+			return nil, err
+		}
+		var pending []confirmedMatch
+		for rows.Next() {
+			var raw []byte
+			var sr searchJobResult
+			if err := rows.Scan(&raw); err != nil {
+				rows.Close()
+				return nil, err
+			}
+			if err := json.Unmarshal(raw, &sr); err != nil {
+				continue
+			}
+			for _, c := range sr.Candidates {
+				pending = append(pending, c.confirmed(j.RunID, it.ID))
+			}
+		}
+		rows.Close()
+
+		if len(pending) > 0 {
+			ir, skip, err := w.loadItemRun(*it, false)
+			if err != nil {
+				return nil, err
+			}
+			if skip != "" {
+				for _, c := range pending {
+					c.trace.finish(decisionNotKept, "item "+skip, 0)
+				}
+			} else {
+				log.Printf("Ranking %d candidates for item %q across all sites (limit %d)\n", len(pending), it.Text, p.MaxMatches)
+				res.Accepted, _ = w.acceptRanked(j.RunID, ir, pending, p.MaxMatches, found)
+			}
+		}
+	}
+	matchesFound := found + res.Accepted
+
+	// Log completion of this item
+	// Only count as success if we have actual matches (not soft-deleted)
+	success := matchesFound > 0
+	description := fmt.Sprintf("Item '%s' completed with %d match(es)", it.Text, matchesFound)
+	w.stats[j.RunID].ItemsProcessed++
+	if err := insertLog(j.RunID, description, success); err != nil {
+		log.Printf("Failed to insert log for item %q: %v\n", it.Text, err)
+	} else {
+		log.Printf("LOG: %s (success=%v, matchesFound=%d)\n", description, success, matchesFound)
+
+		// Broadcast new log via WebSocket
+		broadcastNewLog(map[string]any{
+			"description": description,
+			"success":     success,
+			"timestamp":   time.Now().Format(time.RFC3339),
+			"run_id":      j.RunID,
+		})
+	}
+	return res, nil
+}
+
+// runMagnet extracts a new match's magnet link and queues its notification.
+// Without Playwright nothing is extracted and the match is notified right
+// away; once out of attempts it keeps no magnet link and is notified anyway.
+func (w *jobRunner) runMagnet(j *queueJob) error {
+	var p magnetJobPayload
+	if err := json.Unmarshal(j.Payload, &p); err != nil {
+		return err
+	}
+	if w.pw != nil {
+		log.Printf(">>> EXTRACTING magnet link for match %d from %s\n", p.MatchID, p.URL)
+		magnetLink, err := extractMagnetLinkFromURL(w.pw, p.URL)
+		log.Printf("<<< MAGNET EXTRACTION COMPLETED for %s (error: %v)\n", p.URL, err)
+		if err != nil && !j.final() {
+			return err
+		}
+		if err != nil {
+			log.Printf("Giving up on the magnet link of match %d after %d attempt(s): %v\n", p.MatchID, j.Attempts, err)
+		} else if _, err := db.Exec(`UPDATE matches SET magnet_link = $1 WHERE id = $2`, magnetLink, p.MatchID); err != nil {
+			return err
+		}
+	}
+	// The job is done once the notification is queued, so retrying it can't
+	// notify twice
+	return enqueueJob(j.RunID, jobNotify, derefInt64(j.ItemID), p.Notify)
+}
+
+// runNotify broadcasts a new match and sends its SMS. The WebSocket message
+// only goes out on the first attempt; retries are for the SMS.
+func (w *jobRunner) runNotify(j *queueJob) error {
+	var n matchNotification
+	if err := json.Unmarshal(j.Payload, &n); err != nil {
+		return err
+	}
+	var magnetLink string
+	err := db.QueryRow(`SELECT COALESCE(magnet_link, '') FROM matches WHERE id = $1`, n.MatchID).Scan(&magnetLink)
+	if err == sql.ErrNoRows {
+		return nil // match deleted in the meantime
+	}
+	if err != nil {
+		return err
+	}
+	n.Payload["magnet_link"] = magnetLink
+
+	if n.Upgrade {
+		if j.Attempts == 1 {
+			broadcastMatchUpgrade(n.Payload)
+		}
+		return maybeSendUpgradeSMS(n.Item, n.PreviousQuality, n.Quality, n.Title, n.URL, n.Site)
+	}
+	if j.Attempts == 1 {
+		// Broadcast new match via WebSocket with ID, file_size, seeds, and leechers
+		broadcastNewMatch(n.Payload)
+	}
+	return maybeSendTwilioSMS(n.Item, n.Title, n.URL, n.Site)
+}
+
+func derefInt64(p *int64) int64 {
+	if p == nil {
+		return 0
+	}
+	return *p
+}
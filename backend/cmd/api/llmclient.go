@@ -0,0 +1,302 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// All calls to LLM servers go through an llmClient, which bounds each
+// attempt with a timeout, retries transient failures with exponential
+// backoff, limits how many calls run at once and trips a circuit breaker
+// after repeated transient failures. While the breaker is open calls fail
+// immediately, so the worker falls back to the release parser and fuzzy
+// matching; after a cooldown one probe call is let through.
+//
+// Each purpose has its own breaker, so an endpoint that keeps failing (an
+// embedding model that isn't pulled, say) doesn't stop entity extraction.
+// They share the LLM_MAX_CONCURRENCY slots.
+var (
+	llmSlots     = &llmSemaphore{}
+	llm          = newLLMClient("extraction") // entity extraction
+	embeddingLLM = newLLMClient("embeddings")
+	warmupLLM    = newLLMClient("warmup") // loading a model into memory
+)
+
+func newLLMClient(name string) *llmClient {
+	return &llmClient{name: name, http: &http.Client{}, slots: llmSlots}
+}
+
+// llmBreakers returns the state of every breaker by purpose.
+func llmBreakers() map[string]llmBreakerStatus {
+	out := map[string]llmBreakerStatus{}
+	for _, c := range []*llmClient{llm, embeddingLLM, warmupLLM} {
+		out[c.name] = c.status()
+	}
+	return out
+}
+
+// Circuit breaker states.
+const (
+	breakerClosed   = "closed"
+	breakerOpen     = "open"
+	breakerHalfOpen = "half_open"
+)
+
+var errBreakerOpen = errors.New("LLM circuit breaker is open")
+
+type llmClient struct {
+	name  string
+	http  *http.Client
+	slots *llmSemaphore
+
+	mu          sync.Mutex
+	state       string
+	failures    int // consecutive failed calls
+	openedAt    time.Time
+	probing     bool // a half-open probe is in flight
+	lastError   string
+	lastErrorAt time.Time
+	trips       int
+}
+
+// llmBreakerStatus is the breaker state reported by /api/health and
+// /api/worker-status.
+type llmBreakerStatus struct {
+	State               string `json:"state"`
+	ConsecutiveFailures int    `json:"consecutive_failures"`
+	FailureThreshold    int    `json:"failure_threshold"`
+	Trips               int    `json:"trips"`
+	OpenedAt            string `json:"opened_at,omitempty"`
+	NextProbeAt         string `json:"next_probe_at,omitempty"`
+	LastError           string `json:"last_error,omitempty"`
+	LastErrorAt         string `json:"last_error_at,omitempty"`
+}
+
+// LLM_TIMEOUT_SECONDS (per attempt, default 60; ENTITY_LLM_TIMEOUT_SECONDS is
+// still read), LLM_MAX_RETRIES (default 2), LLM_RETRY_BACKOFF_MS (first
+// backoff, doubled per retry, default 500), LLM_MAX_CONCURRENCY (default 2),
+// LLM_BREAKER_FAILURES (consecutive failed calls that open the breaker,
+// default 5) and LLM_BREAKER_COOLDOWN_SECONDS (default 60).
+func llmTimeout() time.Duration {
+	return time.Duration(getenvInt("LLM_TIMEOUT_SECONDS", getenvInt("ENTITY_LLM_TIMEOUT_SECONDS", 60))) * time.Second
+}
+
+func llmBreakerThreshold() int {
+	if n := getenvInt("LLM_BREAKER_FAILURES", 5); n > 0 {
+		return n
+	}
+	return 1
+}
+
+func llmBreakerCooldown() time.Duration {
+	return time.Duration(getenvInt("LLM_BREAKER_COOLDOWN_SECONDS", 60)) * time.Second
+}
+
+// available reports whether calls are currently let through, without
+// claiming the half-open probe.
+func (c *llmClient) available() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.state != breakerOpen || time.Since(c.openedAt) >= llmBreakerCooldown()
+}
+
+// allow decides whether a call may proceed. Once the cooldown has passed an
+// open breaker goes half-open and lets exactly one probe through.
+func (c *llmClient) allow() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	switch c.state {
+	case breakerOpen:
+		if time.Since(c.openedAt) < llmBreakerCooldown() {
+			return errBreakerOpen
+		}
+		c.state = breakerHalfOpen
+		c.probing = true
+		log.Printf("LLM circuit breaker (%s) half-open, probing\n", c.name)
+		return nil
+	case breakerHalfOpen:
+		if c.probing {
+			return errBreakerOpen
+		}
+		c.probing = true
+		return nil
+	}
+	return nil
+}
+
+// record feeds the outcome of a call to the breaker. Only transient
+// failures (network errors, timeouts, 429 and 5xx) count: a 4xx or an
+// undecodable reply means the server answered, so it is up.
+func (c *llmClient) record(err error) {
+	var retryable retryableError
+	if err != nil && !errors.As(err, &retryable) {
+		err = nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.probing = false
+	if err == nil {
+		if c.state != breakerClosed && c.state != "" {
+			log.Printf("LLM circuit breaker (%s) closed, calls resumed\n", c.name)
+		}
+		c.state = breakerClosed
+		c.failures = 0
+		return
+	}
+	c.failures++
+	c.lastError = err.Error()
+	c.lastErrorAt = time.Now()
+	if c.state == breakerHalfOpen || c.failures >= llmBreakerThreshold() {
+		if c.state != breakerOpen {
+			c.trips++
+			log.Printf("LLM circuit breaker (%s) OPEN after %d consecutive failure(s) (last: %v); calls refused for %s\n",
+				c.name, c.failures, err, llmBreakerCooldown())
+		}
+		c.state = breakerOpen
+		c.openedAt = time.Now()
+	}
+}
+
+// abandon gives back a half-open probe that was allowed but never made, so
+// the next call can probe instead.
+func (c *llmClient) abandon() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.probing = false
+}
+
+func (c *llmClient) status() llmBreakerStatus {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	st := llmBreakerStatus{
+		State:               c.state,
+		ConsecutiveFailures: c.failures,
+		FailureThreshold:    llmBreakerThreshold(),
+		Trips:               c.trips,
+		LastError:           c.lastError,
+	}
+	if st.State == "" {
+		st.State = breakerClosed
+	}
+	if !c.lastErrorAt.IsZero() {
+		st.LastErrorAt = c.lastErrorAt.UTC().Format(time.RFC3339)
+	}
+	if c.state == breakerOpen {
+		st.OpenedAt = c.openedAt.UTC().Format(time.RFC3339)
+		st.NextProbeAt = c.openedAt.Add(llmBreakerCooldown()).UTC().Format(time.RFC3339)
+	}
+	return st
+}
+
+// llmSemaphore limits the LLM calls running at once (LLM_MAX_CONCURRENCY).
+type llmSemaphore struct {
+	once sync.Once
+	sem  chan struct{}
+}
+
+func (c *llmSemaphore) acquire(ctx context.Context) error {
+	c.once.Do(func() {
+		n := getenvInt("LLM_MAX_CONCURRENCY", 2)
+		if n < 1 {
+			n = 1
+		}
+		c.sem = make(chan struct{}, n)
+	})
+	select {
+	case c.sem <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (c *llmSemaphore) release() { <-c.sem }
+
+// retryableError marks failures worth another attempt: network errors,
+// timeouts, 429 and 5xx responses.
+type retryableError struct{ err error }
+
+func (e retryableError) Error() string { return e.err.Error() }
+func (e retryableError) Unwrap() error { return e.err }
+
+// postJSON posts body as JSON and decodes the JSON response into out.
+func (c *llmClient) postJSON(ctx context.Context, url, bearer string, body, out any) error {
+	return c.postJSONTimeout(ctx, llmTimeout(), url, bearer, body, out)
+}
+
+// postJSONTimeout is postJSON with its own per-attempt timeout, for calls
+// like loading a model that take much longer than a regular LLM call.
+func (c *llmClient) postJSONTimeout(ctx context.Context, timeout time.Duration, url, bearer string, body, out any) error {
+	jsonData, err := json.Marshal(body)
+	if err != nil {
+		return fmt.Errorf("failed to marshal request: %w", err)
+	}
+	// Check the breaker before queueing so calls don't wait for a slot
+	// only to be refused
+	if err := c.allow(); err != nil {
+		return err
+	}
+	if err := c.slots.acquire(ctx); err != nil {
+		c.abandon()
+		return err
+	}
+	defer c.slots.release()
+
+	retries := getenvInt("LLM_MAX_RETRIES", 2)
+	backoff := time.Duration(getenvInt("LLM_RETRY_BACKOFF_MS", 500)) * time.Millisecond
+	for attempt := 0; ; attempt++ {
+		err = c.attempt(ctx, timeout, url, bearer, jsonData, out)
+		var retryable retryableError
+		if err == nil || !errors.As(err, &retryable) || attempt >= retries || ctx.Err() != nil {
+			break
+		}
+		wait := backoff << attempt
+		log.Printf("LLM call to %s failed (attempt %d/%d): %v - retrying in %s\n", url, attempt+1, retries+1, err, wait)
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+		}
+	}
+	c.record(err)
+	return err
+}
+
+func (c *llmClient) attempt(ctx context.Context, timeout time.Duration, url, bearer string, jsonData []byte, out any) error {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(jsonData))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if bearer != "" {
+		req.Header.Set("Authorization", "Bearer "+bearer)
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return retryableError{err}
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		b, _ := io.ReadAll(resp.Body)
+		err := fmt.Errorf("status %d: %s", resp.StatusCode, string(b))
+		if resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500 {
+			return retryableError{err}
+		}
+		return err
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("failed to decode response: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestBreakerIgnoresClientErrors(t *testing.T) {
+	useFreshLLM(t)
+	t.Setenv("LLM_MAX_RETRIES", "0")
+	t.Setenv("LLM_BREAKER_FAILURES", "2")
+	srv := httptest.NewServer(http.NotFoundHandler())
+	defer srv.Close()
+
+	var out map[string]any
+	for i := 0; i < 5; i++ {
+		if err := embeddingLLM.postJSON(context.Background(), srv.URL+"/api/embeddings", "", map[string]string{}, &out); err == nil {
+			t.Fatal("postJSON succeeded against a 404")
+		}
+	}
+	if st := embeddingLLM.status(); st.State != breakerClosed {
+		t.Errorf("breaker %s after 404s, want closed", st.State)
+	}
+}
+
+func TestBreakersArePerPurpose(t *testing.T) {
+	useFreshLLM(t)
+	t.Setenv("LLM_MAX_RETRIES", "0")
+	t.Setenv("LLM_BREAKER_FAILURES", "2")
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "down", http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+
+	var out map[string]any
+	for i := 0; i < 3; i++ {
+		embeddingLLM.postJSON(context.Background(), srv.URL, "", map[string]string{}, &out)
+	}
+	if st := embeddingLLM.status(); st.State != breakerOpen {
+		t.Errorf("embeddings breaker %s after 503s, want open", st.State)
+	}
+	if err := embeddingLLM.postJSON(context.Background(), srv.URL, "", map[string]string{}, &out); err != errBreakerOpen {
+		t.Errorf("err = %v, want errBreakerOpen", err)
+	}
+	if !llm.available() || llm.status().State != breakerClosed {
+		t.Errorf("extraction breaker %s, want it unaffected", llm.status().State)
+	}
+}
@@ -0,0 +1,500 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ollamaHTTP is used for Ollama's quick endpoints (model list, liveness);
+// generation goes through llm.
+var ollamaHTTP = &http.Client{Timeout: 5 * time.Second}
+
+// The model switched to through PUT /api/admin/ollama/model. It is kept in
+// the settings table so the choice survives a restart, and overrides
+// OLLAMA_MODEL while set.
+var (
+	activeOllamaModelMu sync.RWMutex
+	activeOllamaModel   string
+)
+
+const settingOllamaModel = "ollama_model"
+
+func activeOllamaModelName() string {
+	activeOllamaModelMu.RLock()
+	defer activeOllamaModelMu.RUnlock()
+	return activeOllamaModel
+}
+
+// loadActiveOllamaModel restores the model switched to before a restart.
+func loadActiveOllamaModel() {
+	var model string
+	err := db.QueryRow(`SELECT value FROM settings WHERE key = $1`, settingOllamaModel).Scan(&model)
+	if err == sql.ErrNoRows {
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to load the active Ollama model: %v\n", err)
+		return
+	}
+	activeOllamaModelMu.Lock()
+	activeOllamaModel = model
+	activeOllamaModelMu.Unlock()
+	log.Printf("Using Ollama model %q set through the admin API\n", model)
+}
+
+// setActiveOllamaModel switches the model and reports whether the choice
+// was stored; if not, it only lasts until the next restart.
+func setActiveOllamaModel(model string) bool {
+	activeOllamaModelMu.Lock()
+	activeOllamaModel = model
+	activeOllamaModelMu.Unlock()
+	if _, err := db.Exec(`
+        INSERT INTO settings(key, value) VALUES ($1, $2)
+        ON CONFLICT (key) DO UPDATE SET value = EXCLUDED.value, updated_at = CURRENT_TIMESTAMP
+    `, settingOllamaModel, model); err != nil {
+		log.Printf("Failed to store the active Ollama model: %v\n", err)
+		return false
+	}
+	return true
+}
+
+// ollamaWarmupTimeout bounds the start-up call that loads the model into
+// memory: OLLAMA_WARMUP_TIMEOUT_SECONDS, default 600.
+func ollamaWarmupTimeout() time.Duration {
+	return time.Duration(getenvInt("OLLAMA_WARMUP_TIMEOUT_SECONDS", 600)) * time.Second
+}
+
+// -------------------- Supervisor --------------------
+
+// ollamaSupervisor owns the `ollama serve` process we started and restarts
+// it with exponential backoff (1s doubling up to a minute) whenever it
+// exits. The backoff resets once the process has stayed up for a minute.
+type ollamaSupervisor struct {
+	mu         sync.Mutex
+	cmd        *exec.Cmd
+	stopping   bool
+	restarts   int
+	startedAt  time.Time
+	lastExit   string
+	lastExitAt time.Time
+}
+
+var ollamaProc = &ollamaSupervisor{}
+
+const (
+	ollamaMinBackoff = time.Second
+	ollamaMaxBackoff = time.Minute
+)
+
+// start spawns `ollama serve` and supervises it from a goroutine.
+func (s *ollamaSupervisor) start() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.cmd != nil {
+		return nil
+	}
+	if err := s.spawnLocked(); err != nil {
+		return err
+	}
+	go s.watch()
+	return nil
+}
+
+func (s *ollamaSupervisor) spawnLocked() error {
+	cmd := exec.Command("ollama", "serve")
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("failed to start Ollama: %w", err)
+	}
+	s.cmd = cmd
+	s.startedAt = time.Now()
+	log.Printf("Ollama server started with PID %d", cmd.Process.Pid)
+	return nil
+}
+
+// watch waits for the process to exit and restarts it until stop is called.
+func (s *ollamaSupervisor) watch() {
+	backoff := ollamaMinBackoff
+	for {
+		s.mu.Lock()
+		cmd := s.cmd
+		s.mu.Unlock()
+
+		err := cmd.Wait()
+
+		s.mu.Lock()
+		if s.stopping {
+			s.cmd = nil
+			s.mu.Unlock()
+			return
+		}
+		if time.Since(s.startedAt) >= ollamaMaxBackoff {
+			backoff = ollamaMinBackoff
+		}
+		s.lastExit = "exited"
+		if err != nil {
+			s.lastExit = err.Error()
+		}
+		s.lastExitAt = time.Now()
+		log.Printf("OLLAMA_EXITED (%s)\n", s.lastExit)
+		s.mu.Unlock()
+
+		for {
+			log.Printf("Restarting Ollama in %s\n", backoff)
+			time.Sleep(backoff)
+			backoff = minDuration(backoff*2, ollamaMaxBackoff)
+
+			s.mu.Lock()
+			if s.stopping {
+				s.cmd = nil
+				s.mu.Unlock()
+				return
+			}
+			s.restarts++
+			err := s.spawnLocked()
+			s.mu.Unlock()
+			if err == nil {
+				break
+			}
+			log.Printf("Failed to restart Ollama: %v\n", err)
+		}
+	}
+}
+
+// stop kills the process at shutdown without restarting it.
+func (s *ollamaSupervisor) stop() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.stopping = true
+	if s.cmd == nil || s.cmd.Process == nil {
+		return
+	}
+	log.Println("Stopping Ollama server...")
+	if err := s.cmd.Process.Kill(); err != nil {
+		log.Printf("Failed to stop Ollama: %v", err)
+	} else {
+		log.Println("Ollama server stopped")
+	}
+}
+
+type ollamaProcessStatus struct {
+	Managed    bool   `json:"managed"` // started (and restarted) by this API
+	PID        int    `json:"pid,omitempty"`
+	StartedAt  string `json:"started_at,omitempty"`
+	Restarts   int    `json:"restarts"`
+	LastExit   string `json:"last_exit,omitempty"`
+	LastExitAt string `json:"last_exit_at,omitempty"`
+}
+
+func (s *ollamaSupervisor) status() ollamaProcessStatus {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	st := ollamaProcessStatus{Managed: s.cmd != nil, Restarts: s.restarts, LastExit: s.lastExit}
+	if s.cmd != nil && s.cmd.Process != nil {
+		st.PID = s.cmd.Process.Pid
+		st.StartedAt = s.startedAt.UTC().Format(time.RFC3339)
+	}
+	if !s.lastExitAt.IsZero() {
+		st.LastExitAt = s.lastExitAt.UTC().Format(time.RFC3339)
+	}
+	return st
+}
+
+func minDuration(a, b time.Duration) time.Duration {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func ollamaReachable() bool {
+	resp, err := ollamaHTTP.Get(ollamaBaseURL() + "/api/tags")
+	if err != nil {
+		return false
+	}
+	resp.Body.Close()
+	return true
+}
+
+// startOllama starts a supervised `ollama serve` unless Ollama is already
+// reachable, and waits up to 30 seconds for it to come up.
+func startOllama() error {
+	// Check if Ollama is already running
+	if ollamaReachable() {
+		log.Println("Ollama is already running")
+		return nil
+	}
+
+	// Ollama is not running, start it
+	log.Println("Starting Ollama server...")
+	if err := ollamaProc.start(); err != nil {
+		return err
+	}
+
+	// Wait for Ollama to be ready (max 30 seconds)
+	for i := 0; i < 30; i++ {
+		time.Sleep(1 * time.Second)
+		if ollamaReachable() {
+			log.Println("Ollama server is ready")
+			return nil
+		}
+	}
+
+	return fmt.Errorf("Ollama server did not become ready within 30 seconds")
+}
+
+// -------------------- Models --------------------
+
+// OllamaModel is a locally available model, as listed by /api/tags.
+type OllamaModel struct {
+	Name       string `json:"name"`
+	Size       int64  `json:"size"`
+	ModifiedAt string `json:"modified_at"`
+	Active     bool   `json:"active"` // the model used for entity extraction
+}
+
+func listOllamaModels() ([]OllamaModel, error) {
+	resp, err := ollamaHTTP.Get(ollamaBaseURL() + "/api/tags")
+	if err != nil {
+		return nil, fmt.Errorf("Ollama is not running at %s: %w", ollamaBaseURL(), err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Ollama returned status %d", resp.StatusCode)
+	}
+
+	var result struct {
+		Models []OllamaModel `json:"models"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("failed to decode Ollama models list: %w", err)
+	}
+	active := ollamaModelName()
+	for i := range result.Models {
+		result.Models[i].Active = ollamaModelMatches(result.Models[i].Name, active)
+	}
+	return result.Models, nil
+}
+
+// ollamaModelMatches compares a listed model ("llama3:latest") with a
+// configured name ("llama3").
+func ollamaModelMatches(listed, name string) bool {
+	return listed == name || (!strings.Contains(name, ":") && listed == name+":latest")
+}
+
+// ollamaPullProgress is broadcast over the WebSocket as "ollama_pull"
+// messages while a model downloads.
+type ollamaPullProgress struct {
+	Model     string  `json:"model"`
+	Status    string  `json:"status"`
+	Completed int64   `json:"completed,omitempty"`
+	Total     int64   `json:"total,omitempty"`
+	Percent   float64 `json:"percent,omitempty"`
+	Done      bool    `json:"done"`
+	Error     string  `json:"error,omitempty"`
+}
+
+var (
+	ollamaPullsMux sync.Mutex
+	ollamaPulls    = map[string]bool{} // models being pulled
+)
+
+// pullOllamaModel streams /api/pull and broadcasts its progress, at most
+// every half second unless the status changes.
+func pullOllamaModel(model string) {
+	defer func() {
+		ollamaPullsMux.Lock()
+		delete(ollamaPulls, model)
+		ollamaPullsMux.Unlock()
+	}()
+
+	fail := func(err error) {
+		log.Printf("OLLAMA_PULL_FAILED model=%s: %v\n", model, err)
+		broadcastOllamaPull(ollamaPullProgress{Model: model, Status: "error", Done: true, Error: err.Error()})
+	}
+
+	jsonData, _ := json.Marshal(map[string]any{"model": model, "stream": true})
+	// No client timeout: pulls of large models take a long time
+	resp, err := http.Post(ollamaBaseURL()+"/api/pull", "application/json", bytes.NewBuffer(jsonData))
+	if err != nil {
+		fail(err)
+		return
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		fail(fmt.Errorf("Ollama returned status %d: %s", resp.StatusCode, string(body)))
+		return
+	}
+
+	log.Printf("OLLAMA_PULL_STARTED model=%s\n", model)
+	var last ollamaPullProgress
+	var lastSent time.Time
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		var line struct {
+			Status    string `json:"status"`
+			Completed int64  `json:"completed"`
+			Total     int64  `json:"total"`
+			Error     string `json:"error"`
+		}
+		if err := json.Unmarshal(scanner.Bytes(), &line); err != nil {
+			continue
+		}
+		if line.Error != "" {
+			fail(fmt.Errorf("%s", line.Error))
+			return
+		}
+		p := ollamaPullProgress{Model: model, Status: line.Status, Completed: line.Completed, Total: line.Total}
+		if p.Total > 0 {
+			p.Percent = float64(p.Completed) * 100 / float64(p.Total)
+		}
+		if p.Status == last.Status && time.Since(lastSent) < 500*time.Millisecond {
+			continue
+		}
+		broadcastOllamaPull(p)
+		last, lastSent = p, time.Now()
+	}
+	if err := scanner.Err(); err != nil {
+		fail(err)
+		return
+	}
+	log.Printf("OLLAMA_PULL_DONE model=%s\n", model)
+	broadcastOllamaPull(ollamaPullProgress{Model: model, Status: "success", Percent: 100, Done: true})
+}
+
+func broadcastOllamaPull(p ollamaPullProgress) {
+	wsClientsMux.Lock()
+	defer wsClientsMux.Unlock()
+
+	msg := map[string]any{
+		"type":     "ollama_pull",
+		"progress": p,
+	}
+
+	for client := range wsClients {
+		if err := client.WriteJSON(msg); err != nil {
+			log.Printf("WebSocket write error: %v", err)
+			client.Close()
+			delete(wsClients, client)
+		}
+	}
+}
+
+// -------------------- Handlers --------------------
+
+// ollamaAdminHandler serves /api/admin/ollama/...:
+//
+//	GET  /api/admin/ollama         process, reachability and active model
+//	GET  /api/admin/ollama/models  local models
+//	POST /api/admin/ollama/pull    pull ?model= / form model, progress over the WebSocket
+//	PUT  /api/admin/ollama/model   switch the active model to form model
+func ollamaAdminHandler(w http.ResponseWriter, r *http.Request) {
+	action := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/admin/ollama"), "/")
+	switch action {
+	case "":
+		if r.Method != http.MethodGet {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		writeJSON(w, map[string]any{
+			"url":       ollamaBaseURL(),
+			"reachable": ollamaReachable(),
+			"model":     ollamaModelName(),
+			"process":   ollamaProc.status(),
+			"breaker":   llm.status(),
+			"breakers":  llmBreakers(),
+		})
+
+	case "models":
+		if r.Method != http.MethodGet {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		models, err := listOllamaModels()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+		writeJSON(w, models)
+
+	case "pull":
+		if r.Method != http.MethodPost {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		model := strings.TrimSpace(r.FormValue("model"))
+		if model == "" {
+			http.Error(w, "model required", http.StatusBadRequest)
+			return
+		}
+		ollamaPullsMux.Lock()
+		if ollamaPulls[model] {
+			ollamaPullsMux.Unlock()
+			http.Error(w, "model is already being pulled", http.StatusConflict)
+			return
+		}
+		ollamaPulls[model] = true
+		ollamaPullsMux.Unlock()
+
+		go pullOllamaModel(model)
+		w.WriteHeader(http.StatusAccepted)
+		writeJSON(w, map[string]any{"model": model, "status": "pulling"})
+
+	case "model":
+		if r.Method != http.MethodPut {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		model := strings.TrimSpace(r.FormValue("model"))
+		if model == "" {
+			http.Error(w, "model required", http.StatusBadRequest)
+			return
+		}
+		models, err := listOllamaModels()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+		found := false
+		for _, m := range models {
+			if ollamaModelMatches(m.Name, model) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			http.Error(w, fmt.Sprintf("model %q is not available locally, pull it first", model), http.StatusBadRequest)
+			return
+		}
+		previous := ollamaModelName()
+		persistent := setActiveOllamaModel(model)
+		log.Printf("Ollama model switched from %q to %q (persistent: %v)\n", previous, model, persistent)
+
+		// Load the new model in the background so the next run doesn't wait.
+		// Loading can take minutes, like the warm-up at startup.
+		go func() {
+			ctx, cancel := context.WithTimeout(context.Background(), ollamaWarmupTimeout())
+			defer cancel()
+			var resp OllamaResponse
+			req := OllamaRequest{Model: model, Prompt: "Hello", Stream: false}
+			if err := warmupLLM.postJSONTimeout(ctx, ollamaWarmupTimeout(), ollamaBaseURL()+"/api/generate", "", req, &resp); err != nil {
+				log.Printf("Failed to load model %q: %v\n", model, err)
+			}
+		}()
+		writeJSON(w, map[string]any{"model": model, "previous": previous, "persistent": persistent})
+
+	default:
+		http.NotFound(w, r)
+	}
+}
@@ -0,0 +1,319 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// promptEvalExample is one line of a labeled corpus (JSON lines):
+//
+//	{"title": "The.Matrix.1999.1080p.BluRay.x264-GRP", "entities": {"FILM TITLE": "The Matrix", "YEAR": "1999", "RESOLUTION": "1080p"}}
+//
+// The labels should list every field present in the title: an extracted
+// field that isn't labeled counts against its precision.
+type promptEvalExample struct {
+	Title    string            `json:"title"`
+	Entities map[string]string `json:"entities"`
+}
+
+// promptFieldScore counts one entity type over the corpus.
+type promptFieldScore struct {
+	Expected  int     `json:"expected"`  // titles labeled with the field
+	Extracted int     `json:"extracted"` // titles the model returned it for
+	Correct   int     `json:"correct"`
+	Accuracy  float64 `json:"accuracy"` // correct / expected
+	Precision float64 `json:"precision"`
+}
+
+type promptEvalMiss struct {
+	Title    string `json:"title"`
+	Field    string `json:"field"`
+	Expected string `json:"expected,omitempty"`
+	Got      string `json:"got,omitempty"`
+	Error    string `json:"error,omitempty"`
+}
+
+// promptEvalReport is what eval-prompt prints.
+type promptEvalReport struct {
+	Prompt        string                       `json:"prompt"` // name and version
+	Backend       string                       `json:"backend"`
+	Model         string                       `json:"model"`
+	Titles        int                          `json:"titles"`
+	Failures      int                          `json:"failures"` // titles without a usable answer
+	ExactMatches  int                          `json:"exact_matches"`
+	ExactAccuracy float64                      `json:"exact_accuracy"` // titles with every labeled field right
+	Fields        map[string]*promptFieldScore `json:"fields"`
+	Latency       promptEvalLatency            `json:"latency"`
+	Misses        []promptEvalMiss             `json:"misses,omitempty"`
+}
+
+// promptEvalLatency is per title; batch calls are spread over their titles.
+type promptEvalLatency struct {
+	AvgMs   float64 `json:"avg_ms"`
+	P50Ms   int64   `json:"p50_ms"`
+	P95Ms   int64   `json:"p95_ms"`
+	MaxMs   int64   `json:"max_ms"`
+	TotalMs int64   `json:"total_ms"`
+}
+
+const maxPromptEvalMisses = 100
+
+// runEvalPromptCommand runs a prompt template and model against a labeled
+// corpus and prints per-field accuracy and latency as JSON.
+func runEvalPromptCommand(args []string) error {
+	fs := flag.NewFlagSet("eval-prompt", flag.ContinueOnError)
+	corpusPath := fs.String("corpus", "", "labeled corpus, one JSON object per line (required)")
+	promptID := fs.Int64("prompt", 0, "prompt template id (default: the active version of -name)")
+	name := fs.String("name", promptEntity, "template to use without -prompt: entity or entity_batch")
+	backend := fs.String("backend", "", "ollama or openai (default: ENTITY_EXTRACTOR, else ollama)")
+	model := fs.String("model", "", "model (default: OLLAMA_MODEL / OPENAI_MODEL)")
+	batchSize := fs.Int("batch-size", entityBatchSize(), "titles per prompt for entity_batch templates")
+	limit := fs.Int("limit", 0, "only evaluate the first N titles (0 = all)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *corpusPath == "" {
+		return fmt.Errorf("-corpus is required")
+	}
+
+	var tpl PromptTemplate
+	if *promptID > 0 {
+		var err error
+		if tpl, err = getPromptTemplate(*promptID); err != nil {
+			return fmt.Errorf("prompt %d: %w", *promptID, err)
+		}
+	} else {
+		if _, ok := promptPlaceholders[*name]; !ok {
+			return fmt.Errorf("-name must be entity or entity_batch")
+		}
+		tpl = activePrompt(*name)
+	}
+
+	if *backend == "" {
+		*backend = entityBackend()
+		if *backend == extractorParser {
+			*backend = extractorOllama
+		}
+	}
+	extractor := newEntityExtractor(*backend)
+	switch e := extractor.(type) {
+	case *ollamaExtractor:
+		if *model != "" {
+			e.model = *model
+		}
+	case *openAIExtractor:
+		if *model != "" {
+			e.model = *model
+		}
+	}
+	completer, ok := extractor.(promptCompleter)
+	if !ok {
+		return fmt.Errorf("-backend must be ollama or openai")
+	}
+
+	corpus, err := readPromptEvalCorpus(*corpusPath, *limit)
+	if err != nil {
+		return err
+	}
+
+	report := evaluatePrompt(context.Background(), tpl, completer, corpus, *batchSize)
+	report.Backend = extractor.Name()
+	report.Model = extractor.Model()
+
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(report)
+}
+
+func readPromptEvalCorpus(path string, limit int) ([]promptEvalExample, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var out []promptEvalExample
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		var ex promptEvalExample
+		if err := json.Unmarshal([]byte(text), &ex); err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path, line, err)
+		}
+		if ex.Title == "" {
+			return nil, fmt.Errorf("%s:%d: title required", path, line)
+		}
+		labels := map[string]string{}
+		for field, value := range ex.Entities {
+			t, ok := canonicalEntityType(field)
+			if !ok {
+				return nil, fmt.Errorf("%s:%d: unknown entity type %q", path, line, field)
+			}
+			labels[t] = value
+		}
+		ex.Entities = labels
+		out = append(out, ex)
+		if limit > 0 && len(out) >= limit {
+			break
+		}
+	}
+	return out, scanner.Err()
+}
+
+// evaluatePrompt extracts every title of the corpus with the template,
+// validating the answers like the worker does, and scores them.
+func evaluatePrompt(ctx context.Context, tpl PromptTemplate, completer promptCompleter, corpus []promptEvalExample, batchSize int) *promptEvalReport {
+	report := &promptEvalReport{
+		Prompt: fmt.Sprintf("%s v%d", tpl.Name, tpl.Version),
+		Titles: len(corpus),
+		Fields: map[string]*promptFieldScore{},
+	}
+	if tpl.Name != promptEntityBatch || batchSize < 1 {
+		batchSize = 1
+	}
+
+	latencies := make([]int64, 0, len(corpus))
+	for start := 0; start < len(corpus); start += batchSize {
+		end := start + batchSize
+		if end > len(corpus) {
+			end = len(corpus)
+		}
+		chunk := corpus[start:end]
+		titles := make([]string, len(chunk))
+		for i, ex := range chunk {
+			titles[i] = ex.Title
+		}
+
+		results := map[int]*EntityExtractionResponse{}
+		var err error
+		began := time.Now()
+		if tpl.Name == promptEntityBatch {
+			results, err = extractBatch(ctx, completer, tpl.render(titles...), titles)
+		} else {
+			var raw string
+			if raw, err = completer.generate(ctx, tpl.render(titles[0])); err == nil {
+				var resp *EntityExtractionResponse
+				if resp, err = parseEntityJSON(titles[0], raw); err == nil {
+					results[0] = resp
+				}
+			}
+		}
+		perTitle := time.Since(began).Milliseconds() / int64(len(chunk))
+		fmt.Fprintf(os.Stderr, "evaluated %d/%d titles\n", end, len(corpus))
+
+		for i, ex := range chunk {
+			latencies = append(latencies, perTitle)
+			resp := results[i]
+			if resp == nil {
+				report.Failures++
+				msg := "no valid entry in the batch answer"
+				if err != nil {
+					msg = err.Error()
+				}
+				report.addMiss(promptEvalMiss{Title: ex.Title, Error: msg})
+				resp = &EntityExtractionResponse{}
+			}
+			report.score(ex, resp.Entities)
+		}
+	}
+
+	for _, f := range report.Fields {
+		if f.Expected > 0 {
+			f.Accuracy = float64(f.Correct) / float64(f.Expected)
+		}
+		if f.Extracted > 0 {
+			f.Precision = float64(f.Correct) / float64(f.Extracted)
+		}
+	}
+	if report.Titles > 0 {
+		report.ExactAccuracy = float64(report.ExactMatches) / float64(report.Titles)
+	}
+	report.Latency = summarizeLatencies(latencies)
+	return report
+}
+
+// score compares the extracted entities of one title with its labels.
+func (r *promptEvalReport) score(ex promptEvalExample, entities []Entity) {
+	field := func(t string) *promptFieldScore {
+		if r.Fields[t] == nil {
+			r.Fields[t] = &promptFieldScore{}
+		}
+		return r.Fields[t]
+	}
+
+	exact := true
+	for t, want := range ex.Entities {
+		f := field(t)
+		f.Expected++
+		got := findEntityByType(entities, t)
+		if got != nil && entityValueMatches(t, want, got) {
+			f.Correct++
+			continue
+		}
+		exact = false
+		miss := promptEvalMiss{Title: ex.Title, Field: t, Expected: want}
+		if got != nil {
+			miss.Got = got.Text
+		}
+		r.addMiss(miss)
+	}
+	seen := map[string]bool{}
+	for _, e := range entities {
+		if seen[e.Type] {
+			continue
+		}
+		seen[e.Type] = true
+		field(e.Type).Extracted++
+		if _, labeled := ex.Entities[e.Type]; !labeled {
+			exact = false
+			r.addMiss(promptEvalMiss{Title: ex.Title, Field: e.Type, Got: e.Text})
+		}
+	}
+	if exact {
+		r.ExactMatches++
+	}
+}
+
+func (r *promptEvalReport) addMiss(m promptEvalMiss) {
+	if len(r.Misses) < maxPromptEvalMisses {
+		r.Misses = append(r.Misses, m)
+	}
+}
+
+// entityValueMatches compares a label with an extracted entity: numeric
+// types by value, others by normalized text.
+func entityValueMatches(entityType, want string, got *Entity) bool {
+	expected := Entity{Type: entityType, Text: want}
+	if got.Value != nil && parseEntityValue(&expected) && expected.Value != nil {
+		return *expected.Value == *got.Value
+	}
+	return normalize(want) == normalize(got.Text)
+}
+
+func summarizeLatencies(ms []int64) promptEvalLatency {
+	var out promptEvalLatency
+	if len(ms) == 0 {
+		return out
+	}
+	sorted := append([]int64(nil), ms...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	for _, v := range sorted {
+		out.TotalMs += v
+	}
+	out.AvgMs = float64(out.TotalMs) / float64(len(sorted))
+	out.P50Ms = sorted[len(sorted)/2]
+	out.P95Ms = sorted[len(sorted)*95/100]
+	out.MaxMs = sorted[len(sorted)-1]
+	return out
+}
@@ -0,0 +1,362 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Prompt templates, by name. entity prompts one title ({title}),
+// entity_batch several ({titles}, one "index: title" line each).
+const (
+	promptEntity      = "entity"
+	promptEntityBatch = "entity_batch"
+)
+
+var promptNames = []string{promptEntity, promptEntityBatch}
+
+var promptPlaceholders = map[string]string{
+	promptEntity:      "{title}",
+	promptEntityBatch: "{titles}",
+}
+
+// builtinPrompts seed version 1 of each template and are used when the
+// database has no active version.
+var builtinPrompts = map[string]string{
+	promptEntity: `Extract named entities from this torrent title and return ONLY a JSON object with an "entities" array. No explanations, no text, ONLY JSON.
+
+Schema:
+{
+  "entities": [
+    {
+      "text": "string",
+      "type": "FILM TITLE|YEAR|SEASON|EPISODE|RESOLUTION|SOURCE|VIDEO CODEC|AUDIO|RELEASE GROUP|LANGUAGE|FILE SIZE",
+      "confidence": 0.95
+    }
+  ]
+}
+
+Only use text that appears in the title.
+
+Torrent title: {title}
+
+JSON output:`,
+
+	promptEntityBatch: `Extract named entities from each of these torrent titles and return ONLY a JSON object with a "results" array holding one entry per title, with the title's index. No explanations, no text, ONLY JSON.
+
+Schema:
+{
+  "results": [
+    {
+      "index": 0,
+      "entities": [
+        {
+          "text": "string",
+          "type": "FILM TITLE|YEAR|SEASON|EPISODE|RESOLUTION|SOURCE|VIDEO CODEC|AUDIO|RELEASE GROUP|LANGUAGE|FILE SIZE",
+          "confidence": 0.95
+        }
+      ]
+    }
+  ]
+}
+
+Only use text that appears in the title.
+
+Torrent titles:
+{titles}
+JSON output:`,
+}
+
+// PromptTemplate is one version of a prompt. Versions are immutable apart
+// from their notes and active flag; editing a prompt adds a version.
+type PromptTemplate struct {
+	ID       int64     `json:"id"`
+	Name     string    `json:"name"`
+	Version  int       `json:"version"`
+	Template string    `json:"template"`
+	Notes    string    `json:"notes"`
+	Active   bool      `json:"active"`
+	Created  time.Time `json:"created_at"`
+}
+
+func (p PromptTemplate) render(titles ...string) string {
+	if p.Name == promptEntityBatch {
+		var b strings.Builder
+		for i, t := range titles {
+			fmt.Fprintf(&b, "%d: %s\n", i, t)
+		}
+		return strings.ReplaceAll(p.Template, "{titles}", b.String())
+	}
+	title := ""
+	if len(titles) > 0 {
+		title = titles[0]
+	}
+	return strings.ReplaceAll(p.Template, "{title}", title)
+}
+
+const promptTemplateColumns = `id, name, version, template, COALESCE(notes, ''), active, created_at`
+
+func scanPromptTemplate(row rowScanner) (PromptTemplate, error) {
+	var p PromptTemplate
+	err := row.Scan(&p.ID, &p.Name, &p.Version, &p.Template, &p.Notes, &p.Active, &p.Created)
+	return p, err
+}
+
+// seedPromptTemplates adds the built-in prompts as active version 1 of
+// templates that don't exist yet.
+func seedPromptTemplates(db *sql.DB) error {
+	for _, name := range promptNames {
+		if _, err := db.Exec(`
+            INSERT INTO prompt_templates(name, version, template, notes, active)
+            SELECT $1, 1, $2, 'built-in', true
+            WHERE NOT EXISTS (SELECT 1 FROM prompt_templates WHERE name = $1)
+        `, name, builtinPrompts[name]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// The active templates are cached until one is added or activated.
+var (
+	activePromptsMu sync.Mutex
+	activePrompts   map[string]PromptTemplate
+)
+
+// activePrompt returns the active version of a template, or the built-in
+// prompt (version 0) if there is none.
+func activePrompt(name string) PromptTemplate {
+	activePromptsMu.Lock()
+	defer activePromptsMu.Unlock()
+	if activePrompts == nil {
+		loaded, err := loadActivePrompts()
+		if err != nil {
+			log.Printf("Failed to load prompt templates, using the built-in prompts: %v\n", err)
+			return PromptTemplate{Name: name, Template: builtinPrompts[name]}
+		}
+		activePrompts = loaded
+	}
+	if p, ok := activePrompts[name]; ok {
+		return p
+	}
+	return PromptTemplate{Name: name, Template: builtinPrompts[name]}
+}
+
+func loadActivePrompts() (map[string]PromptTemplate, error) {
+	rows, err := db.Query(`SELECT ` + promptTemplateColumns + ` FROM prompt_templates WHERE active`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := map[string]PromptTemplate{}
+	for rows.Next() {
+		p, err := scanPromptTemplate(rows)
+		if err != nil {
+			return nil, err
+		}
+		out[p.Name] = p
+	}
+	return out, rows.Err()
+}
+
+func resetActivePrompts() {
+	activePromptsMu.Lock()
+	activePrompts = nil
+	activePromptsMu.Unlock()
+}
+
+// entityPromptVersion identifies the active entity and batch prompts in the
+// entity cache key, so activating another version extracts titles again.
+func entityPromptVersion() string {
+	return fmt.Sprintf("%d.%d", activePrompt(promptEntity).Version, activePrompt(promptEntityBatch).Version)
+}
+
+func entityPrompt(title string) string {
+	return activePrompt(promptEntity).render(title)
+}
+
+func batchEntityPrompt(titles []string) string {
+	return activePrompt(promptEntityBatch).render(titles...)
+}
+
+func getPromptTemplate(id int64) (PromptTemplate, error) {
+	return scanPromptTemplate(db.QueryRow(`SELECT `+promptTemplateColumns+` FROM prompt_templates WHERE id = $1`, id))
+}
+
+// activatePromptTemplate makes a version the active one of its template.
+func activatePromptTemplate(tx *sql.Tx, id int64, name string) error {
+	if _, err := tx.Exec(`UPDATE prompt_templates SET active = false WHERE name = $1 AND active`, name); err != nil {
+		return err
+	}
+	_, err := tx.Exec(`UPDATE prompt_templates SET active = true WHERE id = $1`, id)
+	return err
+}
+
+// promptsHandler serves GET /api/prompts (?name=) and POST, which adds a
+// version of a template (form fields name, template, notes; active=true
+// activates it right away).
+func promptsHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		name := r.URL.Query().Get("name")
+		rows, err := db.Query(`
+            SELECT `+promptTemplateColumns+`
+            FROM prompt_templates
+            WHERE ($1 = '' OR name = $1)
+            ORDER BY name ASC, version DESC
+        `, name)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		defer rows.Close()
+
+		out := make([]PromptTemplate, 0, 8)
+		for rows.Next() {
+			p, err := scanPromptTemplate(rows)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			out = append(out, p)
+		}
+		writeJSON(w, out)
+
+	case http.MethodPost:
+		if err := r.ParseForm(); err != nil {
+			http.Error(w, "invalid form", http.StatusBadRequest)
+			return
+		}
+		name := strings.TrimSpace(r.FormValue("name"))
+		template := r.FormValue("template")
+		placeholder, ok := promptPlaceholders[name]
+		if !ok {
+			http.Error(w, "invalid name (use entity or entity_batch)", http.StatusBadRequest)
+			return
+		}
+		if !strings.Contains(template, placeholder) {
+			http.Error(w, "template must contain "+placeholder, http.StatusBadRequest)
+			return
+		}
+		activate := strings.ToLower(r.FormValue("active")) == "true"
+
+		tx, err := db.Begin()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		defer tx.Rollback()
+
+		var id int64
+		var version int
+		err = tx.QueryRow(`
+            INSERT INTO prompt_templates(name, version, template, notes)
+            SELECT $1, COALESCE(MAX(version), 0) + 1, $2, NULLIF($3, '')
+            FROM prompt_templates WHERE name = $1
+            RETURNING id, version
+        `, name, template, strings.TrimSpace(r.FormValue("notes"))).Scan(&id, &version)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if activate {
+			if err := activatePromptTemplate(tx, id, name); err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+		}
+		if err := tx.Commit(); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if activate {
+			resetActivePrompts()
+			log.Printf("PROMPT_ACTIVATED %s v%d\n", name, version)
+		}
+		w.WriteHeader(http.StatusCreated)
+		writeJSON(w, map[string]any{"id": id, "version": version})
+
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// promptHandler serves GET /api/prompts/{id}, PUT (form field active=true to
+// make the version active, notes to change its notes) and DELETE, which
+// refuses the active version.
+func promptHandler(w http.ResponseWriter, r *http.Request) {
+	idStr := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/prompts/"), "/")
+	id, err := strconv.ParseInt(idStr, 10, 64)
+	if err != nil || id <= 0 {
+		http.Error(w, "invalid id", http.StatusBadRequest)
+		return
+	}
+	p, err := getPromptTemplate(id)
+	if err == sql.ErrNoRows {
+		http.Error(w, "not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		writeJSON(w, p)
+
+	case http.MethodPut:
+		if err := r.ParseForm(); err != nil {
+			http.Error(w, "invalid form", http.StatusBadRequest)
+			return
+		}
+		tx, err := db.Begin()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		defer tx.Rollback()
+
+		if _, ok := r.Form["notes"]; ok {
+			if _, err := tx.Exec(`UPDATE prompt_templates SET notes = NULLIF($1, '') WHERE id = $2`, strings.TrimSpace(r.FormValue("notes")), id); err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+		}
+		activate := strings.ToLower(r.FormValue("active")) == "true"
+		if activate {
+			if err := activatePromptTemplate(tx, id, p.Name); err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+		}
+		if err := tx.Commit(); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if activate {
+			resetActivePrompts()
+			log.Printf("PROMPT_ACTIVATED %s v%d\n", p.Name, p.Version)
+		}
+		writeJSON(w, map[string]any{"ok": true})
+
+	case http.MethodDelete:
+		if p.Active {
+			http.Error(w, "cannot delete the active version", http.StatusConflict)
+			return
+		}
+		if _, err := db.Exec(`DELETE FROM prompt_templates WHERE id = $1`, id); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, map[string]any{"ok": true})
+
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,446 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/lib/pq"
+)
+
+// defaultRuntimeMinutes is used for per-minute size limits when the item has
+// no runtime set.
+const defaultRuntimeMinutes = 110
+
+// QualityProfile is a named set of rules deciding which releases are
+// acceptable for an item. Items without a profile use the default one.
+type QualityProfile struct {
+	ID                   int64    `json:"id"`
+	Name                 string   `json:"name"`
+	AllowedResolutions   []string `json:"allowed_resolutions"`
+	PreferredResolutions []string `json:"preferred_resolutions"`
+	AllowedSources       []string `json:"allowed_sources"`
+	MinSizeMB            float64  `json:"min_size_mb"`
+	MaxSizeMB            float64  `json:"max_size_mb"`
+	SizePerMinute        bool     `json:"size_per_minute"` // min/max are MB per runtime minute
+	PreferredCodecs      []string `json:"preferred_codecs"`
+	BlockedTokens        []string `json:"blocked_tokens"`
+	AllowedTokens        []string `json:"allowed_tokens"` // if set, at least one must be present
+	IsDefault            bool     `json:"is_default"`
+}
+
+// qualityRejection describes which profile rule rejected a release.
+type qualityRejection struct {
+	Rule   string
+	Detail string
+}
+
+// Check applies the profile's rules to a release. It returns nil when the
+// release is acceptable.
+func (p *QualityProfile) Check(title string, info ReleaseInfo, runtimeMinutes int) *qualityRejection {
+	tokens := titleTokens(title)
+	lowerTitle := strings.ToLower(title)
+
+	for _, b := range p.BlockedTokens {
+		if tokenPresent(b, tokens, lowerTitle) {
+			return &qualityRejection{"blocked_token", fmt.Sprintf("contains %q", b)}
+		}
+	}
+
+	if len(p.AllowedTokens) > 0 {
+		found := false
+		for _, a := range p.AllowedTokens {
+			if tokenPresent(a, tokens, lowerTitle) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return &qualityRejection{"allowed_tokens", fmt.Sprintf("none of %v present", p.AllowedTokens)}
+		}
+	}
+
+	// Unknown resolution/source passes; we only reject what we can see.
+	if info.Resolution != "" && len(p.AllowedResolutions) > 0 && !containsFold(p.AllowedResolutions, info.Resolution) {
+		return &qualityRejection{"resolution", fmt.Sprintf("%s not in %v", info.Resolution, p.AllowedResolutions)}
+	}
+	if info.Source != "" && len(p.AllowedSources) > 0 && !containsFold(p.AllowedSources, info.Source) {
+		return &qualityRejection{"source", fmt.Sprintf("%s not in %v", info.Source, p.AllowedSources)}
+	}
+
+	if sizeBytes, ok := parseSizeBytes(info.Size); ok && (p.MinSizeMB > 0 || p.MaxSizeMB > 0) {
+		sizeMB := float64(sizeBytes) / (1024 * 1024)
+		minMB, maxMB := p.MinSizeMB, p.MaxSizeMB
+		if p.SizePerMinute {
+			if runtimeMinutes <= 0 {
+				runtimeMinutes = defaultRuntimeMinutes
+			}
+			minMB *= float64(runtimeMinutes)
+			maxMB *= float64(runtimeMinutes)
+		}
+		if minMB > 0 && sizeMB < minMB {
+			return &qualityRejection{"min_size", fmt.Sprintf("%.0f MB < %.0f MB", sizeMB, minMB)}
+		}
+		if maxMB > 0 && sizeMB > maxMB {
+			return &qualityRejection{"max_size", fmt.Sprintf("%.0f MB > %.0f MB", sizeMB, maxMB)}
+		}
+	}
+
+	return nil
+}
+
+// PreferenceScore returns 0..1 describing how well an accepted release fits
+// the profile's preferred resolutions and codecs. Earlier entries in the
+// preference lists score higher.
+func (p *QualityProfile) PreferenceScore(info ReleaseInfo) float64 {
+	score, weight := 0.0, 0.0
+	if len(p.PreferredResolutions) > 0 {
+		weight += 0.7
+		score += 0.7 * listPreference(p.PreferredResolutions, info.Resolution)
+	}
+	if len(p.PreferredCodecs) > 0 {
+		weight += 0.3
+		score += 0.3 * listPreference(p.PreferredCodecs, info.Codec)
+	}
+	if weight == 0 {
+		return 0
+	}
+	return score / weight
+}
+
+func listPreference(list []string, value string) float64 {
+	for i, v := range list {
+		if strings.EqualFold(v, value) {
+			return 1 - float64(i)/float64(len(list))
+		}
+	}
+	return 0
+}
+
+// titleTokens splits a title on anything that isn't a letter or digit.
+func titleTokens(title string) map[string]bool {
+	out := map[string]bool{}
+	for _, t := range strings.FieldsFunc(title, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	}) {
+		out[strings.ToUpper(t)] = true
+	}
+	return out
+}
+
+// tokenPresent matches plain words as whole tokens (so "TS" doesn't match
+// "HITS") and anything containing punctuation or spaces ("WEB-DL", "Director's
+// Cut") as a case-insensitive substring. A word wrapped in asterisks
+// ("*Soundtrack*") matches anywhere, e.g. in "OriginalSoundtrack".
+func tokenPresent(entry string, tokens map[string]bool, lowerTitle string) bool {
+	entry = strings.TrimSpace(entry)
+	if len(entry) > 2 && strings.HasPrefix(entry, "*") && strings.HasSuffix(entry, "*") {
+		return strings.Contains(lowerTitle, strings.ToLower(entry[1:len(entry)-1]))
+	}
+	if entry == "" {
+		return false
+	}
+	for _, r := range entry {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+			return strings.Contains(lowerTitle, strings.ToLower(entry))
+		}
+	}
+	return tokens[strings.ToUpper(entry)]
+}
+
+func containsFold(list []string, value string) bool {
+	for _, v := range list {
+		if strings.EqualFold(v, value) {
+			return true
+		}
+	}
+	return false
+}
+
+// -------------------- DB --------------------
+
+const qualityProfileColumns = `id, name, allowed_resolutions, preferred_resolutions, allowed_sources,
+    min_size_mb, max_size_mb, size_per_minute, preferred_codecs, blocked_tokens, allowed_tokens, is_default`
+
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+func scanQualityProfile(row rowScanner) (*QualityProfile, error) {
+	var p QualityProfile
+	var allowedRes, preferredRes, allowedSrc, preferredCodecs, blocked, allowed []byte
+	if err := row.Scan(&p.ID, &p.Name, &allowedRes, &preferredRes, &allowedSrc,
+		&p.MinSizeMB, &p.MaxSizeMB, &p.SizePerMinute, &preferredCodecs, &blocked, &allowed, &p.IsDefault); err != nil {
+		return nil, err
+	}
+	for _, f := range []struct {
+		raw []byte
+		dst *[]string
+	}{
+		{allowedRes, &p.AllowedResolutions},
+		{preferredRes, &p.PreferredResolutions},
+		{allowedSrc, &p.AllowedSources},
+		{preferredCodecs, &p.PreferredCodecs},
+		{blocked, &p.BlockedTokens},
+		{allowed, &p.AllowedTokens},
+	} {
+		*f.dst = []string{}
+		if len(f.raw) > 0 {
+			if err := json.Unmarshal(f.raw, f.dst); err != nil {
+				return nil, fmt.Errorf("quality profile %d: %w", p.ID, err)
+			}
+		}
+	}
+	return &p, nil
+}
+
+func loadQualityProfiles() (map[int64]*QualityProfile, *QualityProfile, error) {
+	rows, err := db.Query(`SELECT ` + qualityProfileColumns + ` FROM quality_profiles ORDER BY id ASC`)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+
+	profiles := map[int64]*QualityProfile{}
+	var def *QualityProfile
+	for rows.Next() {
+		p, err := scanQualityProfile(rows)
+		if err != nil {
+			return nil, nil, err
+		}
+		profiles[p.ID] = p
+		if p.IsDefault {
+			def = p
+		}
+	}
+	return profiles, def, rows.Err()
+}
+
+// profileForItem returns the item's own profile, or the default one.
+func profileForItem(it Item, profiles map[int64]*QualityProfile, def *QualityProfile) *QualityProfile {
+	if it.QualityProfileID != nil {
+		if p, ok := profiles[*it.QualityProfileID]; ok {
+			return p
+		}
+	}
+	return def
+}
+
+// errDefaultProfileRequired is returned when a save would leave no default
+// profile: items without a profile would then skip every quality check.
+var errDefaultProfileRequired = errors.New("cannot clear is_default on the default profile; make another profile the default instead")
+
+var errUnknownQualityProfile = errors.New("unknown quality_profile_id")
+
+var errProfileNameTaken = errors.New("profile name already exists")
+
+// checkQualityProfileID verifies that an item's profile exists; nil clears it.
+func checkQualityProfileID(id *int64) error {
+	if id == nil {
+		return nil
+	}
+	var exists bool
+	if err := db.QueryRow(`SELECT EXISTS(SELECT 1 FROM quality_profiles WHERE id = $1)`, *id).Scan(&exists); err != nil {
+		return err
+	}
+	if !exists {
+		return errUnknownQualityProfile
+	}
+	return nil
+}
+
+func saveQualityProfile(p *QualityProfile) error {
+	js := func(v []string) []byte {
+		if v == nil {
+			v = []string{}
+		}
+		b, _ := json.Marshal(v)
+		return b
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// The default profile stays the default until another one is made the
+	// default, which demotes it below.
+	if !p.IsDefault && p.ID != 0 {
+		var wasDefault bool
+		err := tx.QueryRow(`SELECT is_default FROM quality_profiles WHERE id = $1 FOR UPDATE`, p.ID).Scan(&wasDefault)
+		if err != nil {
+			return err
+		}
+		if wasDefault {
+			return errDefaultProfileRequired
+		}
+	}
+
+	// Only one profile can be the default.
+	if p.IsDefault {
+		if _, err := tx.Exec(`UPDATE quality_profiles SET is_default = FALSE WHERE id <> $1`, p.ID); err != nil {
+			return err
+		}
+	}
+
+	args := []any{p.Name, js(p.AllowedResolutions), js(p.PreferredResolutions), js(p.AllowedSources),
+		p.MinSizeMB, p.MaxSizeMB, p.SizePerMinute, js(p.PreferredCodecs), js(p.BlockedTokens), js(p.AllowedTokens), p.IsDefault}
+	if p.ID == 0 {
+		err = tx.QueryRow(`
+            INSERT INTO quality_profiles(name, allowed_resolutions, preferred_resolutions, allowed_sources,
+                min_size_mb, max_size_mb, size_per_minute, preferred_codecs, blocked_tokens, allowed_tokens, is_default)
+            VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)
+            RETURNING id
+        `, args...).Scan(&p.ID)
+	} else {
+		var res sql.Result
+		res, err = tx.Exec(`
+            UPDATE quality_profiles SET name=$1, allowed_resolutions=$2, preferred_resolutions=$3, allowed_sources=$4,
+                min_size_mb=$5, max_size_mb=$6, size_per_minute=$7, preferred_codecs=$8, blocked_tokens=$9,
+                allowed_tokens=$10, is_default=$11, updated_at=CURRENT_TIMESTAMP
+            WHERE id=$12
+        `, append(args, p.ID)...)
+		if err == nil {
+			if n, _ := res.RowsAffected(); n == 0 {
+				return sql.ErrNoRows
+			}
+		}
+	}
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) && pqErr.Code == "23505" {
+		return errProfileNameTaken
+	}
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// -------------------- API handlers --------------------
+
+func qualityProfilesHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		rows, err := db.Query(`SELECT ` + qualityProfileColumns + ` FROM quality_profiles ORDER BY name ASC`)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		defer rows.Close()
+
+		out := make([]*QualityProfile, 0, 16)
+		for rows.Next() {
+			p, err := scanQualityProfile(rows)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			out = append(out, p)
+		}
+		writeJSON(w, out)
+
+	case http.MethodPost:
+		var p QualityProfile
+		if err := json.NewDecoder(r.Body).Decode(&p); err != nil {
+			http.Error(w, "invalid JSON body", http.StatusBadRequest)
+			return
+		}
+		p.ID = 0
+		p.Name = strings.TrimSpace(p.Name)
+		if p.Name == "" {
+			http.Error(w, "name required", http.StatusBadRequest)
+			return
+		}
+		if err := saveQualityProfile(&p); err == errProfileNameTaken {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		} else if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		log.Printf("Created quality profile %q (id=%d)", p.Name, p.ID)
+		w.WriteHeader(http.StatusCreated)
+		writeJSON(w, map[string]any{"id": p.ID})
+
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func qualityProfileHandler(w http.ResponseWriter, r *http.Request) {
+	idStr := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/quality-profiles/"), "/")
+	id, err := strconv.ParseInt(idStr, 10, 64)
+	if err != nil || id <= 0 {
+		http.Error(w, "invalid id", http.StatusBadRequest)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		p, err := scanQualityProfile(db.QueryRow(`SELECT `+qualityProfileColumns+` FROM quality_profiles WHERE id=$1`, id))
+		if err == sql.ErrNoRows {
+			http.Error(w, "not found", http.StatusNotFound)
+			return
+		} else if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, p)
+
+	case http.MethodPut:
+		var p QualityProfile
+		if err := json.NewDecoder(r.Body).Decode(&p); err != nil {
+			http.Error(w, "invalid JSON body", http.StatusBadRequest)
+			return
+		}
+		p.ID = id
+		p.Name = strings.TrimSpace(p.Name)
+		if p.Name == "" {
+			http.Error(w, "name required", http.StatusBadRequest)
+			return
+		}
+		if err := saveQualityProfile(&p); err == sql.ErrNoRows {
+			http.Error(w, "not found", http.StatusNotFound)
+			return
+		} else if err == errDefaultProfileRequired {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		} else if err == errProfileNameTaken {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		} else if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, map[string]any{"ok": true})
+
+	case http.MethodDelete:
+		var isDefault bool
+		if err := db.QueryRow(`SELECT is_default FROM quality_profiles WHERE id=$1`, id).Scan(&isDefault); err == sql.ErrNoRows {
+			http.Error(w, "not found", http.StatusNotFound)
+			return
+		} else if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if isDefault {
+			http.Error(w, "cannot delete the default profile; make another profile the default first", http.StatusBadRequest)
+			return
+		}
+		if _, err := db.Exec(`DELETE FROM quality_profiles WHERE id=$1`, id); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, map[string]any{"ok": true})
+
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
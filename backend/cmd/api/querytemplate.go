@@ -0,0 +1,210 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"regexp"
+	"strings"
+
+	"github.com/playwright-community/playwright-go"
+)
+
+// searchQuery is what the worker searches for on a site. Text is the plain
+// query used when a site has no queryTemplate; the other fields fill the
+// template placeholders.
+type searchQuery struct {
+	Text   string
+	Title  string // item text or alias without its year
+	Year   string
+	Season string // wanted season/episode code for series, e.g. "S01" or "S01E05"
+}
+
+func newSearchQuery(name, itemYear string, episode *episodeKey) searchQuery {
+	q := searchQuery{Text: name, Title: removeYear(name), Year: extractYear(name)}
+	if q.Year == "" {
+		q.Year = itemYear
+	}
+	if episode != nil {
+		q.Text = episodeQuery(name, *episode)
+		q.Season = episode.String()
+	}
+	return q
+}
+
+// queryVariant is one way of turning a searchQuery into the text typed into a
+// site's search box.
+type queryVariant struct {
+	Template   string   `json:"template"`
+	Transforms []string `json:"transforms"`
+}
+
+const (
+	transformLowercase          = "lowercase"
+	transformDotify             = "dotify"
+	transformStripPunctuation   = "strip-punctuation"
+	transformDropLeadingArticle = "drop-leading-article"
+)
+
+var (
+	queryPunctuation = regexp.MustCompile(`[^\p{L}\p{N}\s]+`)
+	leadingArticle   = regexp.MustCompile(`(?i)^(the|a|an)\s+`)
+	queryPlaceholder = regexp.MustCompile(`\{(title|year|season)\}`)
+)
+
+// parseQueryVariants reads the query settings of a site config:
+//
+//	"queryTemplate":   "{title} {year}",
+//	"queryTransforms": ["lowercase", "dotify"],
+//	"queryTemplates":  ["{title} {year}", {"template": "{title}", "transforms": ["strip-punctuation"]}]
+//
+// queryTemplates lists extra variants that are searched as well; plain strings
+// use queryTransforms. Without any template the plain query is used.
+func parseQueryVariants(config string) ([]queryVariant, error) {
+	if config == "" {
+		return nil, nil
+	}
+	var cfg struct {
+		QueryTemplate   string            `json:"queryTemplate"`
+		QueryTransforms []string          `json:"queryTransforms"`
+		QueryTemplates  []json.RawMessage `json:"queryTemplates"`
+	}
+	if err := json.Unmarshal([]byte(config), &cfg); err != nil {
+		return nil, err
+	}
+
+	var variants []queryVariant
+	if cfg.QueryTemplate != "" || (len(cfg.QueryTransforms) > 0 && len(cfg.QueryTemplates) == 0) {
+		variants = append(variants, queryVariant{Template: cfg.QueryTemplate, Transforms: cfg.QueryTransforms})
+	}
+	for _, raw := range cfg.QueryTemplates {
+		var v queryVariant
+		var tmpl string
+		if err := json.Unmarshal(raw, &tmpl); err == nil {
+			v = queryVariant{Template: tmpl, Transforms: cfg.QueryTransforms}
+		} else if err := json.Unmarshal(raw, &v); err != nil {
+			return nil, fmt.Errorf("queryTemplates entry %s: %w", raw, err)
+		}
+		variants = append(variants, v)
+	}
+	for _, v := range variants {
+		for _, t := range v.Transforms {
+			switch t {
+			case transformLowercase, transformDotify, transformStripPunctuation, transformDropLeadingArticle:
+			default:
+				return nil, fmt.Errorf("unknown query transform %q", t)
+			}
+		}
+	}
+	return variants, nil
+}
+
+// Render builds the query text for q. An empty template uses the plain query.
+func (v queryVariant) Render(q searchQuery) string {
+	title := q.Title
+	if hasTransform(v.Transforms, transformDropLeadingArticle) {
+		title = leadingArticle.ReplaceAllString(strings.TrimSpace(title), "")
+	}
+
+	var out string
+	if v.Template == "" {
+		out = q.Text
+		if title != q.Title {
+			out = strings.Replace(out, strings.TrimSpace(q.Title), title, 1)
+		}
+	} else {
+		out = queryPlaceholder.ReplaceAllStringFunc(v.Template, func(p string) string {
+			switch p {
+			case "{title}":
+				return title
+			case "{year}":
+				return q.Year
+			default:
+				return q.Season
+			}
+		})
+	}
+
+	if hasTransform(v.Transforms, transformStripPunctuation) {
+		out = queryPunctuation.ReplaceAllString(out, " ")
+	}
+	if hasTransform(v.Transforms, transformLowercase) {
+		out = strings.ToLower(out)
+	}
+	// Empty placeholders leave extra spaces behind
+	words := strings.Fields(out)
+	if hasTransform(v.Transforms, transformDotify) {
+		return strings.Join(words, ".")
+	}
+	return strings.Join(words, " ")
+}
+
+func hasTransform(transforms []string, name string) bool {
+	for _, t := range transforms {
+		if t == name {
+			return true
+		}
+	}
+	return false
+}
+
+// siteQueries returns the distinct query texts to search on a site.
+func siteQueries(s SiteScraper, q searchQuery) []string {
+	g, ok := s.(*GenericScraper)
+	if !ok {
+		return []string{q.Text}
+	}
+	variants, err := parseQueryVariants(g.Config)
+	if err != nil {
+		log.Printf("Ignoring query templates of %s: %v\n", s.Name(), err)
+		variants = nil
+	}
+	if len(variants) == 0 {
+		return []string{q.Text}
+	}
+
+	var queries []string
+	seen := map[string]bool{}
+	for _, v := range variants {
+		text := v.Render(q)
+		if text != "" && !seen[text] {
+			seen[text] = true
+			queries = append(queries, text)
+		}
+	}
+	if len(queries) == 0 {
+		return []string{q.Text}
+	}
+	return queries
+}
+
+// searchVariants runs every query variant of q on a site and merges the
+// results, dropping duplicate result URLs. It only fails if every variant does.
+func searchVariants(ctx context.Context, s SiteScraper, pw *playwright.Playwright, q searchQuery) ([]SearchResult, []string, error) {
+	queries := siteQueries(s, q)
+	var out []SearchResult
+	seen := map[string]bool{}
+	var lastErr error
+	failed := 0
+	for _, query := range queries {
+		results, err := s.Search(ctx, pw, query)
+		if err != nil {
+			log.Printf("scraper %s error for query %q: %v\n", s.Name(), query, err)
+			lastErr = err
+			failed++
+			continue
+		}
+		for _, r := range results {
+			if seen[r.URL] {
+				continue
+			}
+			seen[r.URL] = true
+			out = append(out, r)
+		}
+	}
+	if failed == len(queries) {
+		return nil, queries, lastErr
+	}
+	return out, queries, nil
+}
@@ -0,0 +1,384 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// A worker run is broken into jobs persisted in the jobs table, so a run
+// interrupted by a restart resumes where it stopped. Jobs are claimed with
+// FOR UPDATE SKIP LOCKED, failed jobs are retried with exponential backoff
+// and moved to the dead letter status ("dead") once out of attempts.
+
+// Job kinds.
+const (
+	jobSearch = "search" // search one site for an item
+	jobRank   = "rank"   // rank an item's candidates across sites, log its completion
+	jobMagnet = "magnet" // extract the magnet link of a new match
+	jobNotify = "notify" // WebSocket and SMS notification of a new match
+)
+
+var jobKinds = []string{jobSearch, jobRank, jobMagnet, jobNotify}
+
+// Job statuses.
+const (
+	jobPending = "pending"
+	jobRunning = "running"
+	jobDone    = "done"
+	jobDead    = "dead"
+)
+
+var jobStatuses = []string{jobPending, jobRunning, jobDone, jobDead}
+
+// jobPriorities make new matches' magnet links and notifications go ahead
+// of the remaining searches.
+var jobPriorities = map[string]int{jobNotify: 2, jobMagnet: 1}
+
+// errJobNotReady puts a job back without using an attempt, e.g. a rank job
+// whose searches haven't finished.
+var errJobNotReady = errors.New("job not ready")
+
+type queueJob struct {
+	ID          int64           `json:"id"`
+	RunID       int64           `json:"run_id"`
+	Kind        string          `json:"kind"`
+	ItemID      *int64          `json:"item_id,omitempty"`
+	Payload     json.RawMessage `json:"payload"`
+	Status      string          `json:"status"`
+	Attempts    int             `json:"attempts"`
+	MaxAttempts int             `json:"max_attempts"`
+	RunAt       time.Time       `json:"run_at"`
+	LastError   string          `json:"last_error,omitempty"`
+	Result      json.RawMessage `json:"result,omitempty"`
+	Created     time.Time       `json:"created_at"`
+	Updated     time.Time       `json:"updated_at"`
+}
+
+// final reports whether a failure of this attempt dead-letters the job.
+func (j *queueJob) final() bool {
+	return j.Attempts >= j.MaxAttempts
+}
+
+const jobColumns = `id, run_id, kind, item_id, payload, status, attempts, max_attempts, run_at,
+    COALESCE(last_error, ''), COALESCE(result, 'null'::jsonb), created_at, updated_at`
+
+func scanJob(row rowScanner) (*queueJob, error) {
+	var j queueJob
+	var itemID sql.NullInt64
+	var payload, result []byte
+	if err := row.Scan(&j.ID, &j.RunID, &j.Kind, &itemID, &payload, &j.Status, &j.Attempts, &j.MaxAttempts, &j.RunAt,
+		&j.LastError, &result, &j.Created, &j.Updated); err != nil {
+		return nil, err
+	}
+	if itemID.Valid {
+		j.ItemID = &itemID.Int64
+	}
+	j.Payload = payload
+	if string(result) != "null" {
+		j.Result = result
+	}
+	return &j, nil
+}
+
+// QUEUE_MAX_ATTEMPTS (default 3), QUEUE_RETRY_BACKOFF_SECONDS (first retry
+// delay, doubled per attempt up to an hour, default 30) and
+// QUEUE_RETENTION_DAYS (finished jobs, default 7).
+func queueMaxAttempts() int {
+	if n := getenvInt("QUEUE_MAX_ATTEMPTS", 3); n > 0 {
+		return n
+	}
+	return 1
+}
+
+func queueRetryBackoff(attempt int) time.Duration {
+	d := time.Duration(getenvInt("QUEUE_RETRY_BACKOFF_SECONDS", 30)) * time.Second
+	for i := 1; i < attempt && d < time.Hour; i++ {
+		d *= 2
+	}
+	return minDuration(d, time.Hour)
+}
+
+// enqueueJob adds a job; itemID 0 means none.
+func enqueueJob(runID int64, kind string, itemID int64, payload any) error {
+	payloadJSON, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	var item sql.NullInt64
+	if itemID > 0 {
+		item = sql.NullInt64{Int64: itemID, Valid: true}
+	}
+	_, err = db.Exec(`
+        INSERT INTO jobs(run_id, kind, item_id, payload, priority, max_attempts)
+        VALUES ($1, $2, $3, $4::jsonb, $5, $6)
+    `, runID, kind, item, string(payloadJSON), jobPriorities[kind], queueMaxAttempts())
+	return err
+}
+
+// claimJob takes the next ready job, or returns nil if there is none.
+func claimJob() (*queueJob, error) {
+	j, err := scanJob(db.QueryRow(`
+        UPDATE jobs SET status = $1, attempts = attempts + 1, locked_at = CURRENT_TIMESTAMP, updated_at = CURRENT_TIMESTAMP
+        WHERE id = (
+            SELECT id FROM jobs
+            WHERE status = $2 AND run_at <= CURRENT_TIMESTAMP
+            ORDER BY priority DESC, id ASC
+            FOR UPDATE SKIP LOCKED
+            LIMIT 1
+        )
+        RETURNING `+jobColumns,
+		jobRunning, jobPending))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return j, err
+}
+
+func completeJob(j *queueJob, result any) {
+	resultJSON, _ := json.Marshal(result)
+	if _, err := db.Exec(`
+        UPDATE jobs SET status = $1, result = $2::jsonb, last_error = NULL, locked_at = NULL, updated_at = CURRENT_TIMESTAMP
+        WHERE id = $3
+    `, jobDone, string(resultJSON), j.ID); err != nil {
+		log.Printf("Failed to complete job %d: %v\n", j.ID, err)
+	}
+}
+
+// failJob schedules a retry, or dead-letters the job once out of attempts.
+func failJob(j *queueJob, jobErr error) {
+	if errors.Is(jobErr, errJobNotReady) {
+		if _, err := db.Exec(`
+            UPDATE jobs SET status = $1, attempts = attempts - 1, locked_at = NULL,
+                run_at = CURRENT_TIMESTAMP + interval '5 seconds', updated_at = CURRENT_TIMESTAMP
+            WHERE id = $2
+        `, jobPending, j.ID); err != nil {
+			log.Printf("Failed to postpone job %d: %v\n", j.ID, err)
+		}
+		return
+	}
+
+	status, delay := jobPending, queueRetryBackoff(j.Attempts)
+	if j.final() {
+		status, delay = jobDead, 0
+		log.Printf("JOB_DEAD id=%d kind=%s run=%d after %d attempt(s): %v\n", j.ID, j.Kind, j.RunID, j.Attempts, jobErr)
+	} else {
+		log.Printf("JOB_FAILED id=%d kind=%s run=%d attempt %d/%d: %v - retrying in %s\n", j.ID, j.Kind, j.RunID, j.Attempts, j.MaxAttempts, jobErr, delay)
+	}
+	if _, err := db.Exec(`
+        UPDATE jobs SET status = $1, last_error = $2, locked_at = NULL,
+            run_at = CURRENT_TIMESTAMP + make_interval(secs => $3), updated_at = CURRENT_TIMESTAMP
+        WHERE id = $4
+    `, status, jobErr.Error(), delay.Seconds(), j.ID); err != nil {
+		log.Printf("Failed to record failure of job %d: %v\n", j.ID, err)
+	}
+}
+
+// recoverJobs puts back jobs left running by a process that stopped
+// mid-run. Called at startup, before anything is claimed.
+func recoverJobs() {
+	res, err := db.Exec(`
+        UPDATE jobs SET status = $1, locked_at = NULL, updated_at = CURRENT_TIMESTAMP
+        WHERE status = $2
+    `, jobPending, jobRunning)
+	if err != nil {
+		log.Printf("Failed to recover interrupted jobs: %v\n", err)
+		return
+	}
+	if n, _ := res.RowsAffected(); n > 0 {
+		log.Printf("Recovered %d interrupted job(s), resuming them\n", n)
+	}
+}
+
+// readyJobs counts the jobs that can be claimed now.
+func readyJobs() int {
+	var n int
+	if err := db.QueryRow(`SELECT COUNT(*) FROM jobs WHERE status = $1 AND run_at <= CURRENT_TIMESTAMP`, jobPending).Scan(&n); err != nil {
+		log.Printf("Failed to count ready jobs: %v\n", err)
+	}
+	return n
+}
+
+func pruneJobs() {
+	days := getenvInt("QUEUE_RETENTION_DAYS", 7)
+	if days <= 0 {
+		return
+	}
+	res, err := db.Exec(`
+        DELETE FROM jobs WHERE status = $1 AND updated_at < CURRENT_TIMESTAMP - make_interval(days => $2)
+    `, jobDone, days)
+	if err != nil {
+		log.Printf("Failed to prune jobs: %v\n", err)
+		return
+	}
+	if n, _ := res.RowsAffected(); n > 0 {
+		log.Printf("Pruned %d finished jobs older than %d days\n", n, days)
+	}
+}
+
+// -------------------- API --------------------
+
+// queueHandler serves GET /api/queue: job counts by status and kind, the
+// number of jobs ready now, the oldest pending job and the dead letters.
+func queueHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	rows, err := db.Query(`SELECT status, kind, COUNT(*) FROM jobs GROUP BY status, kind`)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	counts := map[string]map[string]int{}
+	for _, s := range jobStatuses {
+		counts[s] = map[string]int{}
+	}
+	depth := 0
+	for rows.Next() {
+		var status, kind string
+		var n int
+		if err := rows.Scan(&status, &kind, &n); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if counts[status] == nil {
+			counts[status] = map[string]int{}
+		}
+		counts[status][kind] = n
+		if status == jobPending || status == jobRunning {
+			depth += n
+		}
+	}
+
+	var oldest sql.NullTime
+	if err := db.QueryRow(`SELECT MIN(created_at) FROM jobs WHERE status = $1`, jobPending).Scan(&oldest); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	out := map[string]any{
+		"depth":   depth, // pending + running
+		"ready":   readyJobs(),
+		"counts":  counts,
+		"running": workerRunning.Load(),
+	}
+	if oldest.Valid {
+		out["oldest_pending_at"] = oldest.Time
+	}
+	writeJSON(w, out)
+}
+
+// queueJobsHandler serves GET /api/queue/jobs (?status=, ?kind=, ?run_id=,
+// ?limit=, default 50, newest first), e.g. ?status=dead for the failures.
+func queueJobsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	q := r.URL.Query()
+	status, kind := q.Get("status"), q.Get("kind")
+	if status != "" && !containsString(jobStatuses, status) {
+		http.Error(w, "invalid status (use "+strings.Join(jobStatuses, ", ")+")", http.StatusBadRequest)
+		return
+	}
+	if kind != "" && !containsString(jobKinds, kind) {
+		http.Error(w, "invalid kind (use "+strings.Join(jobKinds, ", ")+")", http.StatusBadRequest)
+		return
+	}
+	var runID int64
+	if v := q.Get("run_id"); v != "" {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			http.Error(w, "invalid run_id", http.StatusBadRequest)
+			return
+		}
+		runID = n
+	}
+	limit := 50
+	if v := q.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 || n > 1000 {
+			http.Error(w, "invalid limit (1-1000)", http.StatusBadRequest)
+			return
+		}
+		limit = n
+	}
+
+	rows, err := db.Query(`
+        SELECT `+jobColumns+`
+        FROM jobs
+        WHERE ($1 = '' OR status = $1) AND ($2 = '' OR kind = $2) AND ($3 = 0 OR run_id = $3)
+        ORDER BY id DESC
+        LIMIT $4
+    `, status, kind, runID, limit)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	out := make([]*queueJob, 0, limit)
+	for rows.Next() {
+		j, err := scanJob(rows)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		out = append(out, j)
+	}
+	writeJSON(w, out)
+}
+
+// queueJobHandler serves POST /api/queue/jobs/{id}/retry, which requeues a
+// dead job with fresh attempts, and DELETE /api/queue/jobs/{id} for jobs
+// that aren't running.
+func queueJobHandler(w http.ResponseWriter, r *http.Request) {
+	rest := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/queue/jobs/"), "/")
+	idStr, action, _ := strings.Cut(rest, "/")
+	id, err := strconv.ParseInt(idStr, 10, 64)
+	if err != nil || id <= 0 {
+		http.Error(w, "invalid id", http.StatusBadRequest)
+		return
+	}
+
+	switch {
+	case r.Method == http.MethodPost && action == "retry":
+		res, err := db.Exec(`
+            UPDATE jobs SET status = $1, attempts = 0, run_at = CURRENT_TIMESTAMP, updated_at = CURRENT_TIMESTAMP
+            WHERE id = $2 AND status = $3
+        `, jobPending, id, jobDead)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			http.Error(w, "no dead job with this id", http.StatusNotFound)
+			return
+		}
+		go resumeQueue()
+		writeJSON(w, map[string]any{"ok": true})
+
+	case r.Method == http.MethodDelete && action == "":
+		res, err := db.Exec(`DELETE FROM jobs WHERE id = $1 AND status <> $2`, id, jobRunning)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			http.Error(w, fmt.Sprintf("no job %d that isn't running", id), http.StatusNotFound)
+			return
+		}
+		writeJSON(w, map[string]any{"ok": true})
+
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Weights of the ranking components; they sum to 1.
+const (
+	rankWeightQuality = 0.40
+	rankWeightSeeds   = 0.25
+	rankWeightSize    = 0.15
+	rankWeightTrust   = 0.10
+	rankWeightAge     = 0.10
+
+	// rankAgeHalfLifeDays is how long it takes a match's age component to
+	// halve when matches are sorted by score.
+	rankAgeHalfLifeDays = 30
+)
+
+// scoreBreakdown is stored with every match so the ranking can be explained.
+// Each component is 0..1; Total is their weighted sum.
+type scoreBreakdown struct {
+	Quality   float64 `json:"quality"`
+	Seeds     float64 `json:"seeds"`
+	Size      float64 `json:"size"`
+	SiteTrust float64 `json:"site_trust"`
+	Age       float64 `json:"age"`
+	Total     float64 `json:"total"`
+}
+
+// expectedSizeGB is the plausible size range of a full-length movie per
+// resolution. Anything far outside is likely mislabelled, a sample or fake.
+var expectedSizeGB = map[string][2]float64{
+	"480p":  {0.3, 2.5},
+	"576p":  {0.5, 4},
+	"720p":  {0.7, 6},
+	"1080p": {1.4, 20},
+	"1440p": {3, 30},
+	"2160p": {6, 90},
+}
+
+// scoreMatch ranks a confirmed candidate. Unknown inputs (no seeds count, no
+// size) score a neutral 0.5 so they neither win nor lose on that component.
+// Age is 1 at insertion time; it decays when matches are listed by score.
+func scoreMatch(info ReleaseInfo, profile *QualityProfile, seeds, leechers, fileSize string, siteTrust float64) scoreBreakdown {
+	b := scoreBreakdown{
+		Quality:   qualityComponent(info, profile),
+		Seeds:     seedsComponent(seeds, leechers),
+		Size:      sizeComponent(info, fileSize),
+		SiteTrust: clamp01(siteTrust),
+		Age:       1,
+	}
+	b.Total = rankWeightQuality*b.Quality + rankWeightSeeds*b.Seeds + rankWeightSize*b.Size +
+		rankWeightTrust*b.SiteTrust + rankWeightAge*b.Age
+	return b
+}
+
+func qualityComponent(info ReleaseInfo, profile *QualityProfile) float64 {
+	maxRank := float64(resolutionRanks["2160p"]*100 + sourceRanks["REMUX"])
+	q := float64(qualityRank(info)) / maxRank
+	if profile != nil && (len(profile.PreferredResolutions) > 0 || len(profile.PreferredCodecs) > 0) {
+		q = 0.6*q + 0.4*profile.PreferenceScore(info)
+	}
+	if info.Repack || info.Proper {
+		q += 0.02
+	}
+	return clamp01(q)
+}
+
+func seedsComponent(seeds, leechers string) float64 {
+	s, err := strconv.Atoi(strings.TrimSpace(seeds))
+	if err != nil || s < 0 {
+		return 0.5
+	}
+	if s == 0 {
+		return 0
+	}
+	// 1000+ seeds is as good as it gets; the ratio rewards healthy swarms.
+	volume := math.Min(1, math.Log10(float64(s)+1)/3)
+	ratio := 1.0
+	if l, err := strconv.Atoi(strings.TrimSpace(leechers)); err == nil && l >= 0 {
+		ratio = float64(s) / float64(s+l)
+	}
+	return clamp01(0.8*volume + 0.2*ratio)
+}
+
+func sizeComponent(info ReleaseInfo, fileSize string) float64 {
+	if fileSize == "" {
+		fileSize = info.Size
+	}
+	bytes, ok := parseSizeBytes(fileSize)
+	bounds, known := expectedSizeGB[info.Resolution]
+	if !ok || !known || bytes <= 0 {
+		return 0.5
+	}
+	gb := float64(bytes) / (1 << 30)
+	lo, hi := bounds[0], bounds[1]
+	if info.Episode > 0 {
+		// Single episodes are a fraction of a movie.
+		lo, hi = lo/5, hi/3
+	}
+	switch {
+	case gb < lo:
+		return clamp01(gb / lo)
+	case gb > hi:
+		return clamp01(hi / gb)
+	default:
+		return 1
+	}
+}
+
+// effectiveScoreSQL is the SQL expression of the score of the matches
+// aliased as table: the stored score, whose age component is 1, with that
+// component replaced by one that halves every rankAgeHalfLifeDays since the
+// match was found. The age decay is only ever computed here.
+func effectiveScoreSQL(table string) string {
+	ageDays := fmt.Sprintf(`GREATEST(EXTRACT(EPOCH FROM (CURRENT_TIMESTAMP - %s.created_at)), 0) / 86400`, table)
+	return fmt.Sprintf(`(COALESCE(%s.score, 0) - %g * (1 - POWER(0.5, %s / %d)))`, table, rankWeightAge, ageDays, rankAgeHalfLifeDays)
+}
+
+func clamp01(v float64) float64 {
+	return math.Max(0, math.Min(1, v))
+}
+
+// scraperTrust returns how much we trust a site's results (0..1).
+func scraperTrust(s SiteScraper) float64 {
+	if g, ok := s.(*GenericScraper); ok {
+		return g.Trust
+	}
+	return 0.5
+}
@@ -0,0 +1,388 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// evalSettings are the matching settings a re-evaluation runs with.
+type evalSettings struct {
+	Matcher            string  `json:"matcher"`
+	FuzzyThreshold     float64 `json:"fuzzy_threshold"`
+	EmbeddingThreshold float64 `json:"embedding_threshold"`
+	UseEntityMatching  bool    `json:"use_entity_matching"`
+	QualityProfileID   *int64  `json:"quality_profile_id,omitempty"` // applied to every item instead of its own profile
+}
+
+// currentEvalSettings returns the settings the worker runs with today.
+func currentEvalSettings() evalSettings {
+	m := currentTitleMatcher()
+	return evalSettings{
+		Matcher:            m.Kind,
+		FuzzyThreshold:     m.FuzzyThreshold,
+		EmbeddingThreshold: m.EmbeddingThreshold,
+		UseEntityMatching:  strings.ToLower(os.Getenv("USE_ENTITY_MATCHING")) == "true",
+	}
+}
+
+func (s evalSettings) titleMatcher() titleMatcher {
+	return titleMatcher{Kind: s.Matcher, FuzzyThreshold: s.FuzzyThreshold, EmbeddingThreshold: s.EmbeddingThreshold}
+}
+
+// evalScope selects the stored candidates to re-evaluate.
+type evalScope struct {
+	ItemID    *int64 `json:"item_id,omitempty"`
+	SinceDays int    `json:"since_days,omitempty"`
+}
+
+// evalMetrics is a confusion matrix over labeled candidates: visible matches
+// are positives, matches hidden by the user are negatives.
+type evalMetrics struct {
+	TruePositives  int     `json:"true_positives"`
+	FalsePositives int     `json:"false_positives"`
+	TrueNegatives  int     `json:"true_negatives"`
+	FalseNegatives int     `json:"false_negatives"`
+	Accepted       int     `json:"accepted"` // including unlabeled candidates
+	Precision      float64 `json:"precision"`
+	Recall         float64 `json:"recall"`
+}
+
+func (m *evalMetrics) add(accepted bool, label string) {
+	if accepted {
+		m.Accepted++
+	}
+	switch {
+	case label == labelPositive && accepted:
+		m.TruePositives++
+	case label == labelPositive:
+		m.FalseNegatives++
+	case label == labelNegative && accepted:
+		m.FalsePositives++
+	case label == labelNegative:
+		m.TrueNegatives++
+	}
+}
+
+func (m *evalMetrics) finish() {
+	if tp, fp := m.TruePositives, m.FalsePositives; tp+fp > 0 {
+		m.Precision = float64(tp) / float64(tp+fp)
+	}
+	if tp, fn := m.TruePositives, m.FalseNegatives; tp+fn > 0 {
+		m.Recall = float64(tp) / float64(tp+fn)
+	}
+}
+
+const (
+	labelPositive = "positive"
+	labelNegative = "negative"
+)
+
+// evalChange is a candidate whose decision differs between the current and
+// the proposed settings.
+type evalChange struct {
+	CandidateID int64  `json:"candidate_id"`
+	ItemID      int64  `json:"item_id"`
+	Item        string `json:"item"`
+	Site        string `json:"site"`
+	Title       string `json:"title"`
+	URL         string `json:"url"`
+	Label       string `json:"label,omitempty"`
+	Current     string `json:"current"`
+	Proposed    string `json:"proposed"`
+	Stage       string `json:"stage,omitempty"` // stage that decided the proposed outcome
+}
+
+type evalReport struct {
+	Current    evalSettings `json:"current_settings"`
+	Proposed   evalSettings `json:"proposed_settings"`
+	Candidates int          `json:"candidates"`
+	Labeled    int          `json:"labeled"`
+	Before     evalMetrics  `json:"current"`
+	After      evalMetrics  `json:"proposed"`
+	Changes    []evalChange `json:"changes"`
+	Truncated  bool         `json:"changes_truncated,omitempty"`
+}
+
+const maxEvalChanges = 500
+
+// evalCandidate is a stored candidate joined with its match label.
+type evalCandidate struct {
+	id     int64
+	itemID int64
+	site   string
+	title  string
+	url    string
+	label  string
+}
+
+// reevaluateCandidates re-runs the matching stages (item filters, quality
+// profile, pre-filter, entity and fuzzy matching) over the latest stored
+// candidate of every item/site/URL with both the current and the proposed
+// settings. Stages that depend on the state of a run (upgrades, wanted
+// episodes, ranking) are not replayed. Entities are extracted like in the
+// worker, so titles the release parser can't handle go through the entity
+// cache and the LLM, and the embedding matcher calls Ollama for titles that
+// aren't in the embeddings cache yet.
+func reevaluateCandidates(proposed evalSettings, scope evalScope) (*evalReport, error) {
+	items, err := loadItems()
+	if err != nil {
+		return nil, err
+	}
+	itemsByID := make(map[int64]Item, len(items))
+	for _, it := range items {
+		itemsByID[it.ID] = it
+	}
+	profiles, defaultProfile, err := loadQualityProfiles()
+	if err != nil {
+		return nil, err
+	}
+	if proposed.QualityProfileID != nil && profiles[*proposed.QualityProfileID] == nil {
+		return nil, fmt.Errorf("quality profile %d not found", *proposed.QualityProfileID)
+	}
+
+	rows, err := db.Query(`
+        SELECT DISTINCT ON (c.item_id, c.site, c.url) c.id, c.item_id, c.site, c.title, c.url,
+               CASE
+                   WHEN m.id IS NULL THEN ''
+                   WHEN m.soft_delete = FALSE THEN 'positive'
+                   WHEN COALESCE(m.seeds, '') <> '0' THEN 'negative'
+                   ELSE ''
+               END
+        FROM candidates c
+        LEFT JOIN matches m ON m.item_id = c.item_id AND m.matched_url = c.url AND m.source_site = c.site
+        WHERE ($1::BIGINT IS NULL OR c.item_id = $1)
+          AND ($2::INTEGER <= 0 OR c.created_at >= CURRENT_TIMESTAMP - make_interval(days => $2::INTEGER))
+        ORDER BY c.item_id, c.site, c.url, c.created_at DESC
+    `, scope.ItemID, scope.SinceDays)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var candidates []evalCandidate
+	for rows.Next() {
+		var c evalCandidate
+		if err := rows.Scan(&c.id, &c.itemID, &c.site, &c.title, &c.url, &c.label); err != nil {
+			return nil, err
+		}
+		candidates = append(candidates, c)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	report := &evalReport{Current: currentEvalSettings(), Proposed: proposed, Changes: []evalChange{}}
+	for _, c := range candidates {
+		it, ok := itemsByID[c.itemID]
+		if !ok {
+			continue
+		}
+		report.Candidates++
+		if c.label != "" {
+			report.Labeled++
+		}
+
+		// Entities come from the same place as in the worker: the release
+		// parser, or the entity cache and LLM for titles it can't handle
+		var entities []Entity
+		if report.Current.UseEntityMatching || proposed.UseEntityMatching {
+			if resp, _, err := extractEntitiesForTitle(context.Background(), c.title, nil); err == nil {
+				entities = resp.Entities
+			}
+		}
+
+		before, _ := evaluateTitle(it, c.title, entities, report.Current, profiles, defaultProfile)
+		after, stage := evaluateTitle(it, c.title, entities, proposed, profiles, defaultProfile)
+		report.Before.add(before, c.label)
+		report.After.add(after, c.label)
+
+		if before != after {
+			if len(report.Changes) >= maxEvalChanges {
+				report.Truncated = true
+				continue
+			}
+			report.Changes = append(report.Changes, evalChange{
+				CandidateID: c.id,
+				ItemID:      c.itemID,
+				Item:        it.Text,
+				Site:        c.site,
+				Title:       c.title,
+				URL:         c.url,
+				Label:       c.label,
+				Current:     acceptLabel(before),
+				Proposed:    acceptLabel(after),
+				Stage:       stage,
+			})
+		}
+	}
+	report.Before.finish()
+	report.After.finish()
+	return report, nil
+}
+
+func acceptLabel(accepted bool) string {
+	if accepted {
+		return "accept"
+	}
+	return "reject"
+}
+
+// evaluateTitle runs the matching stages for one title with the entities
+// extracted from it. It returns whether the title would be accepted and the
+// stage that decided it.
+func evaluateTitle(it Item, title string, entities []Entity, settings evalSettings, profiles map[int64]*QualityProfile, defaultProfile *QualityProfile) (bool, string) {
+	trace := &candidateTrace{quiet: true}
+
+	filters, err := compileItemFilters(it)
+	if err == nil {
+		if rejectedBy := filters.Reject(title); rejectedBy != "" {
+			return false, "item_filter"
+		}
+	}
+
+	release := parseReleaseName(title)
+	profile := profileForItem(it, profiles, defaultProfile)
+	if settings.QualityProfileID != nil {
+		profile = profiles[*settings.QualityProfileID]
+	}
+	if profile != nil && profile.Check(title, release, it.RuntimeMinutes) != nil {
+		return false, "quality"
+	}
+
+	names := itemNames(it)
+	var candidateNames []string
+	for _, name := range names {
+		if _, ok := preFilterPhrase(name, title); ok {
+			candidateNames = append(candidateNames, name)
+		}
+	}
+	if len(candidateNames) == 0 {
+		return false, "pre_filter"
+	}
+
+	if !settings.UseEntityMatching {
+		entities = nil
+	}
+	matched := matchNames(candidateNames, extractYear(it.Text), title, entities, settings.UseEntityMatching, settings.titleMatcher(), trace)
+	return matched != "", lastStage(trace)
+}
+
+func lastStage(t *candidateTrace) string {
+	if len(t.stages) == 0 {
+		return ""
+	}
+	return t.stages[len(t.stages)-1].Stage
+}
+
+// parseEvalForm reads proposed settings and scope from a form, starting from
+// the current settings so only the fields being tuned need to be sent.
+func parseEvalForm(r *http.Request) (evalSettings, evalScope, error) {
+	settings := currentEvalSettings()
+	var scope evalScope
+	if v := strings.TrimSpace(r.FormValue("fuzzy_threshold")); v != "" {
+		t, err := strconv.ParseFloat(v, 64)
+		if err != nil || t < 0 || t > 1 {
+			return settings, scope, fmt.Errorf("fuzzy_threshold must be a number between 0 and 1")
+		}
+		settings.FuzzyThreshold = t
+	}
+	if v := strings.TrimSpace(r.FormValue("matcher")); v != "" {
+		if v != matcherFuzzy && v != matcherEmbedding {
+			return settings, scope, fmt.Errorf("matcher must be fuzzy or embedding")
+		}
+		settings.Matcher = v
+	}
+	if v := strings.TrimSpace(r.FormValue("embedding_threshold")); v != "" {
+		t, err := strconv.ParseFloat(v, 64)
+		if err != nil || t < 0 || t > 1 {
+			return settings, scope, fmt.Errorf("embedding_threshold must be a number between 0 and 1")
+		}
+		settings.EmbeddingThreshold = t
+	}
+	if v := strings.TrimSpace(r.FormValue("use_entity_matching")); v != "" {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return settings, scope, fmt.Errorf("use_entity_matching must be true or false")
+		}
+		settings.UseEntityMatching = b
+	}
+	var err error
+	if settings.QualityProfileID, err = parseOptionalID(r.FormValue("quality_profile_id")); err != nil {
+		return settings, scope, fmt.Errorf("invalid quality_profile_id")
+	}
+	if scope.ItemID, err = parseOptionalID(r.FormValue("item_id")); err != nil {
+		return settings, scope, fmt.Errorf("invalid item_id")
+	}
+	if v := strings.TrimSpace(r.FormValue("since_days")); v != "" {
+		if scope.SinceDays, err = strconv.Atoi(v); err != nil {
+			return settings, scope, fmt.Errorf("since_days must be an integer")
+		}
+	}
+	return settings, scope, nil
+}
+
+// reevaluateHandler serves POST /api/reevaluate.
+func reevaluateHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "invalid form", http.StatusBadRequest)
+		return
+	}
+	settings, scope, err := parseEvalForm(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	report, err := reevaluateCandidates(settings, scope)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, report)
+}
+
+// runReevaluateCommand implements `api reevaluate [flags]`, printing the
+// report as JSON.
+func runReevaluateCommand(args []string) error {
+	current := currentEvalSettings()
+	fs := flag.NewFlagSet("reevaluate", flag.ContinueOnError)
+	threshold := fs.Float64("threshold", current.FuzzyThreshold, "proposed FUZZY_THRESHOLD")
+	matcher := fs.String("matcher", current.Matcher, "proposed MATCHER (fuzzy or embedding)")
+	embeddingThreshold := fs.Float64("embedding-threshold", current.EmbeddingThreshold, "proposed EMBEDDING_THRESHOLD")
+	entity := fs.Bool("entity", current.UseEntityMatching, "proposed USE_ENTITY_MATCHING")
+	profileID := fs.Int64("profile", 0, "quality profile id to apply to every item (0 = each item's own)")
+	itemID := fs.Int64("item", 0, "only re-evaluate this item's candidates")
+	sinceDays := fs.Int("since-days", 0, "only candidates from the last N days (0 = all)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if *matcher != matcherFuzzy && *matcher != matcherEmbedding {
+		return fmt.Errorf("-matcher must be fuzzy or embedding")
+	}
+	settings := evalSettings{Matcher: *matcher, FuzzyThreshold: *threshold, EmbeddingThreshold: *embeddingThreshold, UseEntityMatching: *entity}
+	if *profileID > 0 {
+		settings.QualityProfileID = profileID
+	}
+	scope := evalScope{SinceDays: *sinceDays}
+	if *itemID > 0 {
+		scope.ItemID = itemID
+	}
+
+	report, err := reevaluateCandidates(settings, scope)
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(report)
+}
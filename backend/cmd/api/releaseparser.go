@@ -0,0 +1,492 @@
+package main
+
+import (
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ReleaseInfo is the structured result of parsing a scene-style release name
+// such as "Movie.Name.2023.1080p.WEB-DL.x264-GROUP".
+type ReleaseInfo struct {
+	Title      string   `json:"title"`
+	Year       int      `json:"year,omitempty"`
+	Season     int      `json:"season,omitempty"`
+	Episode    int      `json:"episode,omitempty"`
+	Resolution string   `json:"resolution,omitempty"`
+	Source     string   `json:"source,omitempty"`
+	Codec      string   `json:"codec,omitempty"`
+	Audio      []string `json:"audio,omitempty"`
+	HDR        []string `json:"hdr,omitempty"`
+	Group      string   `json:"group,omitempty"`
+	Repack     bool     `json:"repack,omitempty"`
+	Proper     bool     `json:"proper,omitempty"`
+	Languages  []string `json:"languages,omitempty"`
+	Size       string   `json:"size,omitempty"`
+
+	// Parsed is false when no release markers were found, in which case the
+	// title is just the cleaned input and callers may want a smarter fallback.
+	Parsed bool `json:"parsed"`
+
+	spans map[string][2]int
+}
+
+// releaseTag maps a pattern found in a release name to its canonical value.
+type releaseTag struct {
+	re    *regexp.Regexp
+	value string
+}
+
+func tagRe(pattern string) *regexp.Regexp {
+	return regexp.MustCompile(`(?i)\b(?:` + pattern + `)\b`)
+}
+
+// audioRe is like tagRe but also swallows a trailing channel layout, which is
+// usually glued to the codec ("DDP5.1", "AAC2.0").
+func audioRe(pattern string) *regexp.Regexp {
+	return regexp.MustCompile(`(?i)\b(?:` + pattern + `)(?:\d(?:\s\d)?)?\b`)
+}
+
+var (
+	releaseSeparators = strings.NewReplacer(".", " ", "_", " ", "[", " ", "]", " ", "(", " ", ")", " ", "{", " ", "}", " ")
+	releaseExtension  = regexp.MustCompile(`(?i)\.(mkv|mp4|avi|m4v|wmv|mov|ts|iso|torrent)$`)
+	releaseYear       = regexp.MustCompile(`\b(19\d{2}|20\d{2})\b`)
+	releaseEpisode    = regexp.MustCompile(`(?i)\bS(\d{1,2})\s?E(\d{1,3})(?:\s?-?\s?E?\d{1,3})*\b`)
+	releaseCrossEp    = regexp.MustCompile(`(?i)\b(\d{1,2})x(\d{2,3})\b`)
+	releaseSeasonPack = regexp.MustCompile(`(?i)\b(?:S(\d{1,2})|Season\s?(\d{1,2}))\b`)
+	releaseGroup      = regexp.MustCompile(`-\s?([A-Za-z0-9]+)\s*$`)
+	releaseSize       = regexp.MustCompile(`(?i)\b(\d+(?:[.,]\d+)?)\s?(TB|GB|MB|KB|TiB|GiB|MiB|KiB)\b`)
+	releaseRepack     = tagRe(`REPACK\d?|RERIP`)
+	releaseProper     = tagRe(`PROPER`)
+
+	releaseResolutions = []releaseTag{
+		{tagRe(`2160p|4K|UHD`), "2160p"},
+		{tagRe(`1440p`), "1440p"},
+		{tagRe(`1080[pi]`), "1080p"},
+		{tagRe(`720p`), "720p"},
+		{tagRe(`576[pi]`), "576p"},
+		{tagRe(`480[pi]`), "480p"},
+	}
+
+	// Order matters: more specific tags must come before the generic ones
+	// they contain (WEB-DL before WEB, HDCAM before CAM).
+	releaseSources = []releaseTag{
+		{tagRe(`REMUX`), "REMUX"},
+		{tagRe(`Blu-?Ray|BDRip|BRRip|BDRemux|BD25|BD50`), "BluRay"},
+		{tagRe(`WEB-?DL|WEBDL`), "WEB-DL"},
+		{tagRe(`WEB-?Rip`), "WEBRip"},
+		{tagRe(`HDTV|PDTV|SDTV`), "HDTV"},
+		{tagRe(`DVD-?Rip|DVD-?R|DVD5|DVD9|DVD`), "DVD"},
+		{tagRe(`HDRip`), "HDRip"},
+		{tagRe(`DVDSCR|SCREENER|SCR`), "SCREENER"},
+		{tagRe(`HDCAM|CAM-?Rip|CAM`), "CAM"},
+		{tagRe(`HDTS|TELESYNC|TS`), "TELESYNC"},
+		{tagRe(`TELECINE|TC`), "TELECINE"},
+		{tagRe(`WEB`), "WEB"},
+	}
+
+	releaseCodecs = []releaseTag{
+		{tagRe(`[xh]\s?265|HEVC`), "x265"},
+		{tagRe(`[xh]\s?264|AVC`), "x264"},
+		{tagRe(`AV1`), "AV1"},
+		{tagRe(`VP9`), "VP9"},
+		{tagRe(`XviD`), "XviD"},
+		{tagRe(`DivX`), "DivX"},
+	}
+
+	releaseAudio = []releaseTag{
+		{tagRe(`Atmos`), "Atmos"},
+		{audioRe(`TrueHD`), "TrueHD"},
+		{audioRe(`DTS-?HD(?:\s?MA)?|DTS-?X`), "DTS-HD"},
+		{audioRe(`DTS`), "DTS"},
+		{audioRe(`DDP|E-?AC-?3`), "DDP"},
+		{audioRe(`DD|AC-?3|Dolby\s?Digital`), "DD"},
+		{audioRe(`AAC`), "AAC"},
+		{tagRe(`FLAC`), "FLAC"},
+		{tagRe(`MP3`), "MP3"},
+		{tagRe(`Opus`), "Opus"},
+	}
+
+	releaseHDR = []releaseTag{
+		{tagRe(`HDR10\+|HDR10Plus`), "HDR10+"},
+		{tagRe(`HDR10`), "HDR10"},
+		{tagRe(`HDR`), "HDR"},
+		{tagRe(`DV|DoVi|Dolby\s?Vision`), "DV"},
+		{tagRe(`HLG`), "HLG"},
+	}
+
+	releaseLanguages = []releaseTag{
+		{tagRe(`MULTi`), "MULTI"},
+		{tagRe(`DUAL(?:-?AUDIO)?`), "DUAL"},
+		{tagRe(`ENG|ENGLISH`), "ENGLISH"},
+		{tagRe(`FRENCH|VFF|VFQ|TRUEFRENCH`), "FRENCH"},
+		{tagRe(`GERMAN`), "GERMAN"},
+		{tagRe(`SPANISH|ESP|CASTELLANO|LATINO`), "SPANISH"},
+		{tagRe(`ITALIAN|ITA`), "ITALIAN"},
+		{tagRe(`RUSSIAN|RUS`), "RUSSIAN"},
+		{tagRe(`HINDI`), "HINDI"},
+		{tagRe(`JAPANESE|JAP`), "JAPANESE"},
+		{tagRe(`KOREAN|KOR`), "KOREAN"},
+		{tagRe(`CHINESE|CHS|CHT`), "CHINESE"},
+		{tagRe(`PORTUGUESE|PT-?BR`), "PORTUGUESE"},
+		{tagRe(`SUBBED|HC`), "SUBBED"},
+	}
+
+	// releaseMarkers are the tags that end a title.
+	releaseMarkers = concatTags(releaseResolutions, releaseSources, releaseCodecs, releaseAudio, releaseHDR,
+		[]releaseTag{{releaseRepack, "REPACK"}, {releaseProper, "PROPER"}})
+)
+
+func concatTags(sets ...[]releaseTag) []releaseTag {
+	var out []releaseTag
+	for _, set := range sets {
+		out = append(out, set...)
+	}
+	return out
+}
+
+// parseReleaseName extracts structured fields from a release name without any
+// network calls. It is deterministic: the same input always yields the same
+// output, which makes it safe to use on every candidate on every run.
+func parseReleaseName(name string) ReleaseInfo {
+	info := ReleaseInfo{spans: map[string][2]int{}}
+
+	raw := name
+	if loc := releaseExtension.FindStringIndex(raw); loc != nil {
+		raw = raw[:loc[0]]
+	}
+	// Separators are replaced byte-for-byte so that offsets in the cleaned
+	// string still line up with the original title.
+	clean := releaseSeparators.Replace(raw)
+
+	// titleEnd is the offset of the first release marker; everything before
+	// it is considered the title.
+	titleEnd := len(clean)
+	mark := func(field string, loc []int) {
+		if loc == nil {
+			return
+		}
+		if _, ok := info.spans[field]; !ok {
+			info.spans[field] = [2]int{loc[0], loc[1]}
+		}
+		if loc[0] < titleEnd {
+			titleEnd = loc[0]
+		}
+	}
+
+	var episode []int
+	if m := releaseEpisode.FindStringSubmatchIndex(clean); m != nil {
+		info.Season, _ = strconv.Atoi(clean[m[2]:m[3]])
+		info.Episode, _ = strconv.Atoi(clean[m[4]:m[5]])
+		episode = m[:2]
+	} else if m := releaseCrossEp.FindStringSubmatchIndex(clean); m != nil {
+		info.Season, _ = strconv.Atoi(clean[m[2]:m[3]])
+		info.Episode, _ = strconv.Atoi(clean[m[4]:m[5]])
+		episode = m[:2]
+	} else if m := releaseSeasonPack.FindStringSubmatchIndex(clean); m != nil {
+		if m[2] >= 0 {
+			info.Season, _ = strconv.Atoi(clean[m[2]:m[3]])
+		} else {
+			info.Season, _ = strconv.Atoi(clean[m[4]:m[5]])
+		}
+		episode = m[:2]
+	}
+
+	// The title ends at an anchor, the release year or the season/episode
+	// marker, and tags are only looked for after it: titles are full of
+	// words that are also tags ("Charlotte's Web", "Mr Holland's Opus",
+	// "Cam"). Without an anchor the whole name is searched and the title
+	// ends at the first tag.
+	episodeStart := len(clean)
+	if episode != nil {
+		episodeStart = episode[0]
+	}
+	year := releaseYearAnchor(clean, episodeStart)
+	tagsFrom := 0
+	switch {
+	case year != nil && year[0] < episodeStart:
+		tagsFrom = year[1]
+	case episode != nil:
+		tagsFrom = episode[1]
+	}
+	anchored := year != nil || episode != nil
+	if episode != nil {
+		mark("season", episode)
+	}
+
+	tags := clean[tagsFrom:]
+	shift := func(loc []int) []int {
+		if loc == nil {
+			return nil
+		}
+		return []int{tagsFrom + loc[0], tagsFrom + loc[1]}
+	}
+	if v, loc := firstTag(tags, releaseResolutions); v != "" {
+		info.Resolution = v
+		mark("resolution", shift(loc))
+	}
+	if v, loc := firstTag(tags, releaseSources); v != "" {
+		info.Source = v
+		mark("source", shift(loc))
+	}
+	if v, loc := firstTag(tags, releaseCodecs); v != "" {
+		info.Codec = v
+		mark("codec", shift(loc))
+	}
+	if vals, loc := allTags(tags, releaseAudio); len(vals) > 0 {
+		info.Audio = vals
+		mark("audio", shift(loc))
+	}
+	if vals, loc := allTags(tags, releaseHDR); len(vals) > 0 {
+		info.HDR = vals
+		mark("hdr", shift(loc))
+	}
+	if loc := releaseRepack.FindStringIndex(tags); loc != nil {
+		info.Repack = true
+		mark("repack", shift(loc))
+	}
+	if loc := releaseProper.FindStringIndex(tags); loc != nil {
+		info.Proper = true
+		mark("proper", shift(loc))
+	}
+
+	if !anchored {
+		// Pick the year last, once the tags have delimited the title. A year
+		// at the very start is only used when nothing else is left for the
+		// title ("2012.1080p.BluRay" is handled below).
+		for _, y := range releaseYear.FindAllStringSubmatchIndex(clean, -1) {
+			if y[0] < titleEnd {
+				year = y
+				continue
+			}
+			if year == nil {
+				year = y
+			}
+			break
+		}
+		if year != nil && year[0] == 0 && titleEnd == len(clean) {
+			year = nil
+		}
+	} else if year == nil {
+		// A year after the episode marker is an air date, not part of the title
+		year = releaseYear.FindStringSubmatchIndex(tags)
+		if year != nil {
+			year = []int{tagsFrom + year[0], tagsFrom + year[1], tagsFrom + year[2], tagsFrom + year[3]}
+		}
+	}
+	if year != nil {
+		info.Year, _ = strconv.Atoi(clean[year[2]:year[3]])
+		mark("year", year[:2])
+	}
+
+	// Language tokens are common English words in titles ("The Italian Job"),
+	// so only look for them after the title has been delimited.
+	if titleEnd < len(clean) {
+		if vals, loc := allTags(clean[titleEnd:], releaseLanguages); len(vals) > 0 {
+			info.Languages = vals
+			info.spans["language"] = [2]int{titleEnd + loc[0], titleEnd + loc[1]}
+		}
+	}
+	if m := releaseSize.FindStringSubmatchIndex(raw[tagsFrom:]); m != nil {
+		info.Size = strings.ReplaceAll(raw[tagsFrom+m[2]:tagsFrom+m[3]], ",", ".") + " " + strings.ToUpper(raw[tagsFrom+m[4]:tagsFrom+m[5]])
+		mark("size", shift(m[:2]))
+	}
+
+	info.Parsed = titleEnd < len(clean)
+
+	// The release group is the trailing "-GROUP" suffix, but only when it
+	// comes after the title so hyphenated titles ("Spider-Man") are left alone.
+	if m := releaseGroup.FindStringSubmatchIndex(clean); m != nil && info.Parsed && m[0] >= titleEnd {
+		group := clean[m[2]:m[3]]
+		if !isKnownReleaseTag(group) {
+			info.Group = group
+			info.spans["group"] = [2]int{m[2], m[3]}
+		}
+	}
+
+	titleRaw := strings.TrimRight(clean[:titleEnd], " -:")
+	titleStart := len(titleRaw) - len(strings.TrimLeft(titleRaw, " -"))
+	title := strings.Join(strings.Fields(titleRaw[titleStart:]), " ")
+	if title == "" && info.Year != 0 {
+		// Titles that are just a number, e.g. "2012.1080p.BluRay".
+		title = strconv.Itoa(info.Year)
+		info.Year = 0
+		info.spans["title"] = info.spans["year"]
+		delete(info.spans, "year")
+	}
+	info.Title = title
+	if title == "" {
+		info.Parsed = false
+	} else if _, ok := info.spans["title"]; !ok {
+		info.spans["title"] = [2]int{titleStart, len(titleRaw)}
+	}
+	return info
+}
+
+// releaseYearAnchor finds the release year among the years before limit
+// (the episode marker): the last one before the first tag that follows a
+// year, so years that belong to the title stay in it ("Blade Runner 2049
+// 2017 1080p"). A year at the very start is part of the title ("2001 A
+// Space Odyssey 1968").
+func releaseYearAnchor(clean string, limit int) []int {
+	var years [][]int
+	for _, y := range releaseYear.FindAllStringSubmatchIndex(clean, -1) {
+		if y[0] > 0 && y[0] < limit {
+			years = append(years, y)
+		}
+	}
+	if len(years) == 0 {
+		return nil
+	}
+	end := limit
+	after := years[0][1]
+	if _, loc := firstTag(clean[after:], releaseMarkers); loc != nil && after+loc[0] < end {
+		end = after + loc[0]
+	}
+	var year []int
+	for _, y := range years {
+		if y[0] < end {
+			year = y
+		}
+	}
+	return year
+}
+
+func firstTag(s string, tags []releaseTag) (string, []int) {
+	best := -1
+	var bestLoc []int
+	value := ""
+	for _, t := range tags {
+		if loc := t.re.FindStringIndex(s); loc != nil && (best < 0 || loc[0] < best) {
+			best = loc[0]
+			bestLoc = loc
+			value = t.value
+		}
+	}
+	return value, bestLoc
+}
+
+// allTags returns every distinct canonical value found, in order of first
+// appearance, together with the span of the earliest one.
+func allTags(s string, tags []releaseTag) ([]string, []int) {
+	type hit struct {
+		value string
+		pos   int
+		loc   []int
+	}
+	var hits []hit
+	claimed := [][]int{}
+	for _, t := range tags {
+		loc := t.re.FindStringIndex(s)
+		if loc == nil {
+			continue
+		}
+		// Skip matches that sit inside a more specific tag already found
+		// (DTS inside DTS-HD, HDR inside HDR10).
+		overlaps := false
+		for _, c := range claimed {
+			if loc[0] < c[1] && c[0] < loc[1] {
+				overlaps = true
+				break
+			}
+		}
+		if overlaps {
+			continue
+		}
+		claimed = append(claimed, loc)
+		hits = append(hits, hit{t.value, loc[0], loc})
+	}
+	if len(hits) == 0 {
+		return nil, nil
+	}
+	sort.SliceStable(hits, func(i, j int) bool { return hits[i].pos < hits[j].pos })
+	out := make([]string, 0, len(hits))
+	for _, h := range hits {
+		out = append(out, h.value)
+	}
+	return out, hits[0].loc
+}
+
+func isKnownReleaseTag(token string) bool {
+	for _, set := range [][]releaseTag{releaseResolutions, releaseSources, releaseCodecs, releaseAudio, releaseHDR} {
+		for _, t := range set {
+			if loc := t.re.FindStringIndex(token); loc != nil && loc[0] == 0 && loc[1] == len(token) {
+				return true
+			}
+		}
+	}
+	return strings.EqualFold(token, "DL") || strings.EqualFold(token, "Rip")
+}
+
+// Entities converts the parsed fields into the same Entity shape the LLM
+// extractor produces, so the rest of the matching pipeline doesn't care which
+// one ran.
+func (ri ReleaseInfo) Entities() []Entity {
+	var out []Entity
+	add := func(entityType, field, text string) {
+		if text == "" {
+			return
+		}
+		e := Entity{Text: text, Type: entityType, Confidence: 1}
+		if span, ok := ri.spans[field]; ok {
+			e.Start, e.End = span[0], span[1]
+		}
+		parseEntityValue(&e)
+		out = append(out, e)
+	}
+
+	add(entityFilmTitle, "title", ri.Title)
+	if ri.Year != 0 {
+		add(entityYear, "year", strconv.Itoa(ri.Year))
+	}
+	if ri.Season != 0 {
+		add(entitySeason, "season", strconv.Itoa(ri.Season))
+	}
+	if ri.Episode != 0 {
+		add(entityEpisode, "season", strconv.Itoa(ri.Episode))
+	}
+	add(entityResolution, "resolution", ri.Resolution)
+	add(entitySource, "source", ri.Source)
+	add(entityVideoCodec, "codec", ri.Codec)
+	add(entityAudio, "audio", strings.Join(ri.Audio, " "))
+	add(entityHDR, "hdr", strings.Join(ri.HDR, " "))
+	add(entityReleaseGroup, "group", ri.Group)
+	if ri.Repack {
+		add(entityRepack, "repack", "REPACK")
+	}
+	if ri.Proper {
+		add(entityProper, "proper", "PROPER")
+	}
+	add(entityLanguage, "language", strings.Join(ri.Languages, " "))
+	add(entityFileSize, "size", ri.Size)
+	return out
+}
+
+var sizeValue = regexp.MustCompile(`(?i)^\s*(\d+(?:[.,]\d+)?)\s*([KMGT]i?B|B|bytes)?\s*$`)
+
+// parseSizeBytes converts a human-readable size ("4.3 GB", "700MiB") to bytes.
+// Sizes are treated as binary multiples, which is what torrent sites mean in
+// practice regardless of whether they write GB or GiB.
+func parseSizeBytes(s string) (int64, bool) {
+	m := sizeValue.FindStringSubmatch(s)
+	if m == nil {
+		return 0, false
+	}
+	n, err := strconv.ParseFloat(strings.ReplaceAll(m[1], ",", "."), 64)
+	if err != nil {
+		return 0, false
+	}
+	mult := 1.0
+	switch strings.TrimSuffix(strings.ToUpper(m[2]), "IB") {
+	case "K", "KB":
+		mult = 1 << 10
+	case "M", "MB":
+		mult = 1 << 20
+	case "G", "GB":
+		mult = 1 << 30
+	case "T", "TB":
+		mult = 1 << 40
+	}
+	return int64(n * mult), true
+}
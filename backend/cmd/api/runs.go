@@ -0,0 +1,342 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// What started a worker run.
+const (
+	runTriggerSchedule = "schedule" // due items/sites, see schedules.go
+	runTriggerManual   = "manual"   // POST /api/trigger-worker
+	runTriggerStartup  = "startup"  // RUN_WORKER_ON_START
+	runTriggerUnknown  = "unknown"  // jobs queued before runs were recorded
+)
+
+var runTriggers = []string{runTriggerSchedule, runTriggerManual, runTriggerStartup, runTriggerUnknown}
+
+// Run statuses. A run stays running while it has pending or running jobs,
+// including retries waiting for their backoff.
+const (
+	runRunning            = "running"
+	runCompleted          = "completed"
+	runCompletedWithError = "completed_with_errors" // some jobs were dead-lettered
+	runFailed             = "failed"                // the run couldn't be planned
+)
+
+var runStatuses = []string{runRunning, runCompleted, runCompletedWithError, runFailed}
+
+// siteTiming sums up the searches of one site during a run.
+type siteTiming struct {
+	Searches int     `json:"searches"` // queries sent, including errors
+	Results  int     `json:"results"`
+	Errors   int     `json:"errors"`
+	TotalMs  int64   `json:"total_ms"`
+	AvgMs    float64 `json:"avg_ms"`
+}
+
+// workerRunStats are the counters of one worker run, stored in the
+// worker_runs table. Jobs of the run update them as they finish.
+type workerRunStats struct {
+	RunID              int64                  `json:"run_id"`
+	Trigger            string                 `json:"trigger"`
+	Status             string                 `json:"status"`
+	StartedAt          time.Time              `json:"started_at"`
+	FinishedAt         *time.Time             `json:"finished_at,omitempty"`
+	ItemsProcessed     int                    `json:"items_processed"` // items whose searches all finished
+	SitesProcessed     int                    `json:"sites_processed"` // distinct sites searched
+	CandidatesSeen     int                    `json:"candidates_seen"` // search results evaluated
+	LLMCalls           int                    `json:"llm_calls"`
+	MatchesInserted    int                    `json:"matches_inserted"` // including auto-hidden ones
+	Errors             int                    `json:"errors"`           // failed searches and failed job attempts
+	LastError          string                 `json:"last_error,omitempty"`
+	EntityCacheHits    int                    `json:"entity_cache_hits"`
+	EntityCacheMisses  int                    `json:"entity_cache_misses"`
+	EntityCacheHitRate float64                `json:"entity_cache_hit_rate"` // hits / lookups, 0 without lookups
+	SiteTimings        map[string]*siteTiming `json:"site_timings"`
+}
+
+func (s *workerRunStats) entityCacheHit() {
+	if s != nil {
+		s.EntityCacheHits++
+	}
+}
+
+func (s *workerRunStats) entityCacheMiss() {
+	if s != nil {
+		s.EntityCacheMisses++
+	}
+}
+
+func (s *workerRunStats) llmCall() {
+	if s != nil {
+		s.LLMCalls++
+	}
+}
+
+func (s *workerRunStats) recordError(err error) {
+	s.Errors++
+	s.LastError = err.Error()
+}
+
+// recordSearch adds one query sent to a site.
+func (s *workerRunStats) recordSearch(site string, took time.Duration, results int, err error) {
+	t := s.SiteTimings[site]
+	if t == nil {
+		t = &siteTiming{}
+		s.SiteTimings[site] = t
+	}
+	t.Searches++
+	t.Results += results
+	t.TotalMs += took.Milliseconds()
+	t.AvgMs = float64(t.TotalMs) / float64(t.Searches)
+	if err != nil {
+		t.Errors++
+		s.recordError(fmt.Errorf("%s: %w", site, err))
+	}
+}
+
+func (s *workerRunStats) update() {
+	s.SitesProcessed = len(s.SiteTimings)
+	if lookups := s.EntityCacheHits + s.EntityCacheMisses; lookups > 0 {
+		s.EntityCacheHitRate = float64(s.EntityCacheHits) / float64(lookups)
+	}
+}
+
+var (
+	lastRunStatsMux sync.Mutex
+	lastRunStats    *workerRunStats
+)
+
+const runColumns = `id, trigger, status, started_at, finished_at, items_processed, sites_processed, candidates_seen,
+    llm_calls, matches_inserted, errors, COALESCE(last_error, ''), entity_cache_hits, entity_cache_misses, site_timings`
+
+func scanRun(row rowScanner) (*workerRunStats, error) {
+	s := &workerRunStats{}
+	var finished sql.NullTime
+	var timings []byte
+	if err := row.Scan(&s.RunID, &s.Trigger, &s.Status, &s.StartedAt, &finished, &s.ItemsProcessed, &s.SitesProcessed,
+		&s.CandidatesSeen, &s.LLMCalls, &s.MatchesInserted, &s.Errors, &s.LastError, &s.EntityCacheHits,
+		&s.EntityCacheMisses, &timings); err != nil {
+		return nil, err
+	}
+	if finished.Valid {
+		s.FinishedAt = &finished.Time
+	}
+	s.SiteTimings = map[string]*siteTiming{}
+	if err := json.Unmarshal(timings, &s.SiteTimings); err != nil {
+		return nil, err
+	}
+	s.update()
+	return s, nil
+}
+
+// startRun records a new run.
+func startRun(runID int64, trigger string) (*workerRunStats, error) {
+	return scanRun(db.QueryRow(`
+        INSERT INTO worker_runs(id, trigger, status) VALUES ($1, $2, $3)
+        RETURNING `+runColumns, runID, trigger, runRunning))
+}
+
+// loadRun returns a run's stats, recording the run if jobs refer to one
+// without a row (queued before runs were recorded).
+func loadRun(runID int64) (*workerRunStats, error) {
+	s, err := scanRun(db.QueryRow(`SELECT `+runColumns+` FROM worker_runs WHERE id = $1`, runID))
+	if err == sql.ErrNoRows {
+		if _, err := db.Exec(`
+            INSERT INTO worker_runs(id, trigger, status) VALUES ($1, $2, $3) ON CONFLICT (id) DO NOTHING
+        `, runID, runTriggerUnknown, runRunning); err != nil {
+			return nil, err
+		}
+		return scanRun(db.QueryRow(`SELECT `+runColumns+` FROM worker_runs WHERE id = $1`, runID))
+	}
+	return s, err
+}
+
+func saveRun(s *workerRunStats) {
+	s.update()
+	timings, _ := json.Marshal(s.SiteTimings)
+	if _, err := db.Exec(`
+        UPDATE worker_runs SET status = $1, finished_at = CASE WHEN $2 THEN COALESCE(finished_at, CURRENT_TIMESTAMP) END,
+            items_processed = $3, sites_processed = $4, candidates_seen = $5, llm_calls = $6, matches_inserted = $7, errors = $8, last_error = NULLIF($9, ''),
+            entity_cache_hits = $10, entity_cache_misses = $11, site_timings = $12::jsonb
+        WHERE id = $13
+    `, s.Status, s.FinishedAt != nil, s.ItemsProcessed, s.SitesProcessed, s.CandidatesSeen, s.LLMCalls, s.MatchesInserted,
+		s.Errors, s.LastError, s.EntityCacheHits, s.EntityCacheMisses, string(timings), s.RunID); err != nil {
+		log.Printf("Failed to save run %d: %v\n", s.RunID, err)
+	}
+}
+
+// runJobsLeft counts the jobs of a run that are still pending or running,
+// and the dead ones.
+func runJobsLeft(runID int64) (left, dead int, err error) {
+	err = db.QueryRow(`
+        SELECT COUNT(*) FILTER (WHERE status IN ($2, $3)), COUNT(*) FILTER (WHERE status = $4)
+        FROM jobs WHERE run_id = $1
+    `, runID, jobPending, jobRunning, jobDead).Scan(&left, &dead)
+	return left, dead, err
+}
+
+// finishRun completes a run none of whose jobs is left to run; dead jobs
+// make it completed_with_errors.
+func finishRun(s *workerRunStats, dead int) {
+	now := time.Now()
+	s.FinishedAt = &now
+	if s.Status == runRunning {
+		s.Status = runCompleted
+		if dead > 0 {
+			s.Status = runCompletedWithError
+		}
+	}
+	saveRun(s)
+}
+
+// -------------------- API --------------------
+
+// runsHandler serves GET /api/runs: runs newest first, filtered by
+// ?status= and ?trigger= (?limit=, default 50).
+func runsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	q := r.URL.Query()
+	status, trigger := q.Get("status"), q.Get("trigger")
+	if status != "" && !containsString(runStatuses, status) {
+		http.Error(w, "invalid status (use "+strings.Join(runStatuses, ", ")+")", http.StatusBadRequest)
+		return
+	}
+	if trigger != "" && !containsString(runTriggers, trigger) {
+		http.Error(w, "invalid trigger (use "+strings.Join(runTriggers, ", ")+")", http.StatusBadRequest)
+		return
+	}
+	limit := 50
+	if v := q.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 || n > 500 {
+			http.Error(w, "invalid limit (1-500)", http.StatusBadRequest)
+			return
+		}
+		limit = n
+	}
+
+	rows, err := db.Query(`
+        SELECT `+runColumns+`
+        FROM worker_runs
+        WHERE ($1 = '' OR status = $1) AND ($2 = '' OR trigger = $2)
+        ORDER BY id DESC
+        LIMIT $3
+    `, status, trigger, limit)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	out := make([]*workerRunStats, 0, limit)
+	for rows.Next() {
+		s, err := scanRun(rows)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		out = append(out, s)
+	}
+	writeJSON(w, out)
+}
+
+// runHandler serves GET /api/runs/{id}: the run with its logs, the visible
+// matches it inserted and its job counts by status.
+func runHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	id, err := strconv.ParseInt(strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/runs/"), "/"), 10, 64)
+	if err != nil || id <= 0 {
+		http.Error(w, "invalid id", http.StatusBadRequest)
+		return
+	}
+	run, err := scanRun(db.QueryRow(`SELECT `+runColumns+` FROM worker_runs WHERE id = $1`, id))
+	if err == sql.ErrNoRows {
+		http.Error(w, "run not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	logs := []map[string]any{}
+	rows, err := db.Query(`SELECT id, timestamp, description, success FROM logs WHERE run_id = $1 ORDER BY timestamp ASC, id ASC`, id)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	for rows.Next() {
+		var logID int64
+		var ts, description string
+		var success bool
+		if err := rows.Scan(&logID, &ts, &description, &success); err != nil {
+			rows.Close()
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		logs = append(logs, map[string]any{"id": logID, "timestamp": ts, "description": description, "success": success})
+	}
+	rows.Close()
+
+	matches := []Match{}
+	rows, err = db.Query(`
+        SELECT `+matchColumns+`
+        FROM matches m
+        JOIN items i ON i.id = m.item_id
+        WHERE m.run_id = $1 AND m.soft_delete = FALSE
+        ORDER BY m.created_at ASC
+    `, id)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	for rows.Next() {
+		m, err := scanMatch(rows)
+		if err != nil {
+			rows.Close()
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		matches = append(matches, m)
+	}
+	rows.Close()
+
+	jobs := map[string]int{}
+	rows, err = db.Query(`SELECT status, COUNT(*) FROM jobs WHERE run_id = $1 GROUP BY status`, id)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var status string
+		var n int
+		if err := rows.Scan(&status, &n); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		jobs[status] = n
+	}
+
+	writeJSON(w, map[string]any{
+		"run":     run,
+		"logs":    logs,
+		"matches": matches,
+		"jobs":    jobs,
+	})
+}
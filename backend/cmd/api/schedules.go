@@ -0,0 +1,560 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// Schedule scopes. The global schedule applies to every item and site
+// without an override of its own.
+const (
+	scheduleGlobal = "global"
+	scheduleItem   = "item"
+	scheduleSite   = "site"
+)
+
+// Schedule is a cron expression (see parseCron) for the global run, an item
+// or a site. Item schedules (the global one for items without their own)
+// say how often an item is checked. Site schedules restrict when a site is
+// searched at all: only in the window after each of its times. Sites without
+// one are searched whenever an item is due. A disabled schedule never comes
+// due, so its items or site are only searched by manual runs.
+type Schedule struct {
+	ID        int64      `json:"id"`
+	Scope     string     `json:"scope"`
+	ItemID    *int64     `json:"item_id,omitempty"`
+	URLID     *int64     `json:"url_id,omitempty"`
+	Cron      string     `json:"cron"`
+	Enabled   bool       `json:"enabled"`
+	LastRunAt *time.Time `json:"last_run_at,omitempty"`
+	NextRunAt *time.Time `json:"next_run_at,omitempty"`
+	Created   time.Time  `json:"created_at"`
+	Updated   time.Time  `json:"updated_at"`
+
+	parsed *cronSchedule
+}
+
+const scheduleColumns = `id, scope, item_id, url_id, cron, enabled, created_at, updated_at`
+
+func scanSchedule(row rowScanner) (Schedule, error) {
+	var s Schedule
+	var itemID, urlID sql.NullInt64
+	if err := row.Scan(&s.ID, &s.Scope, &itemID, &urlID, &s.Cron, &s.Enabled, &s.Created, &s.Updated); err != nil {
+		return s, err
+	}
+	if itemID.Valid {
+		s.ItemID = &itemID.Int64
+	}
+	if urlID.Valid {
+		s.URLID = &urlID.Int64
+	}
+	parsed, err := parseCron(s.Cron)
+	if err != nil {
+		log.Printf("Schedule %d has an invalid cron expression %q: %v - disabled\n", s.ID, s.Cron, err)
+		s.Enabled = false
+	}
+	s.parsed = parsed
+	return s, nil
+}
+
+// seedGlobalSchedule creates the global schedule on first start, from
+// CRON_SCHEDULE or else CHECK_INTERVAL_HOURS.
+func seedGlobalSchedule(db *sql.DB) error {
+	expr := strings.TrimSpace(os.Getenv("CRON_SCHEDULE"))
+	if expr == "" {
+		hours := getenvInt("CHECK_INTERVAL_HOURS", 6)
+		if hours <= 0 {
+			hours = 6
+		}
+		expr = fmt.Sprintf("@every %dh", hours)
+	}
+	if _, err := parseCron(expr); err != nil {
+		return fmt.Errorf("CRON_SCHEDULE: %w", err)
+	}
+	_, err := db.Exec(`
+        INSERT INTO schedules(scope, cron) SELECT $1, $2
+        WHERE NOT EXISTS (SELECT 1 FROM schedules WHERE scope = $1)
+    `, scheduleGlobal, expr)
+	return err
+}
+
+// siteScheduleWindow is SITE_SCHEDULE_WINDOW_MINUTES (default 60), how long
+// after each of its cron times a site with a schedule may be searched.
+func siteScheduleWindow() time.Duration {
+	return time.Duration(getenvInt("SITE_SCHEDULE_WINDOW_MINUTES", 60)) * time.Minute
+}
+
+// due reports whether a time of the schedule has passed since the last run
+// of the item/site pair (zero if it never ran, which is always due). A run
+// missed while the API was down is made up once.
+func (s *Schedule) due(last, now time.Time) bool {
+	if !s.Enabled || s.parsed == nil {
+		return false
+	}
+	if last.IsZero() {
+		return true
+	}
+	if s.parsed.every > 0 {
+		return !last.Add(s.parsed.every).After(now)
+	}
+	next := s.parsed.next(last)
+	return !next.IsZero() && !next.After(now)
+}
+
+// nextDue returns the first time from on at which the schedule is due, or
+// the zero time if it never is.
+func (s *Schedule) nextDue(last, from time.Time) time.Time {
+	if !s.Enabled || s.parsed == nil {
+		return time.Time{}
+	}
+	if s.due(last, from) {
+		return from
+	}
+	if s.parsed.every > 0 {
+		return last.Add(s.parsed.every)
+	}
+	return s.parsed.next(last)
+}
+
+// open reports whether now is in the site window of the schedule, i.e.
+// within siteScheduleWindow of one of its times. @every schedules are always
+// open.
+func (s *Schedule) open(now time.Time) bool {
+	if s.parsed.every > 0 {
+		return true
+	}
+	next := s.parsed.next(now.Add(-siteScheduleWindow()))
+	return !next.IsZero() && !next.After(now)
+}
+
+// nextOpen returns the first time from on at which the site schedule is due
+// and open.
+func (s *Schedule) nextOpen(last, from time.Time) time.Time {
+	t := s.nextDue(last, from)
+	if t.IsZero() || s.open(t) {
+		return t
+	}
+	return s.parsed.next(t)
+}
+
+// schedulePair is the effective schedules of an item on a site; site is nil
+// for sites without a schedule.
+type schedulePair struct {
+	item, site *Schedule
+}
+
+func (p schedulePair) due(last, now time.Time) bool {
+	if !p.item.due(last, now) {
+		return false
+	}
+	return p.site == nil || (p.site.due(last, now) && p.site.open(now))
+}
+
+// next returns the first time from on at which the pair is due. Once due,
+// an item stays due until it runs, so this is the site's next opening after
+// the item comes due.
+func (p schedulePair) next(last, from time.Time) time.Time {
+	t := p.item.nextDue(last, from)
+	if t.IsZero() || p.site == nil {
+		return t
+	}
+	return p.site.nextOpen(last, t)
+}
+
+// scheduleSet is every schedule, by scope.
+type scheduleSet struct {
+	global *Schedule
+	items  map[int64]*Schedule
+	sites  map[int64]*Schedule
+	all    []*Schedule
+}
+
+func loadSchedules() (*scheduleSet, error) {
+	rows, err := db.Query(`SELECT ` + scheduleColumns + ` FROM schedules ORDER BY scope = 'global' DESC, scope ASC, id ASC`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	set := &scheduleSet{items: map[int64]*Schedule{}, sites: map[int64]*Schedule{}}
+	for rows.Next() {
+		s, err := scanSchedule(rows)
+		if err != nil {
+			return nil, err
+		}
+		sp := &s
+		set.all = append(set.all, sp)
+		switch {
+		case s.Scope == scheduleGlobal:
+			set.global = sp
+		case s.Scope == scheduleItem && s.ItemID != nil:
+			set.items[*s.ItemID] = sp
+		case s.Scope == scheduleSite && s.URLID != nil:
+			set.sites[*s.URLID] = sp
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if set.global == nil {
+		set.global = &Schedule{Scope: scheduleGlobal}
+	}
+	return set, nil
+}
+
+func (set *scheduleSet) pair(itemID, urlID int64) schedulePair {
+	p := schedulePair{item: set.global, site: set.sites[urlID]}
+	if s := set.items[itemID]; s != nil {
+		p.item = s
+	}
+	return p
+}
+
+type pairKey struct{ itemID, urlID int64 }
+
+// loadPairRuns returns when each item was last searched on each site by a
+// scheduled run.
+func loadPairRuns() (map[pairKey]time.Time, error) {
+	rows, err := db.Query(`SELECT item_id, url_id, last_run_at FROM schedule_runs`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := map[pairKey]time.Time{}
+	for rows.Next() {
+		var k pairKey
+		var t time.Time
+		if err := rows.Scan(&k.itemID, &k.urlID, &t); err != nil {
+			return nil, err
+		}
+		out[k] = t
+	}
+	return out, rows.Err()
+}
+
+// runPlan lists the sites to search per item id; a nil plan searches every
+// item on every site.
+type runPlan map[int64]map[int64]bool
+
+func (p runPlan) includes(itemID int64) bool {
+	return p == nil || len(p[itemID]) > 0
+}
+
+func (p runPlan) site(itemID, urlID int64) bool {
+	return p == nil || p[itemID][urlID]
+}
+
+// planScheduledRun collects the item/site pairs that are due at now and
+// records them as run, so a pair is planned once per due time even if the
+// item is then skipped (satisfied, no missing episodes).
+func planScheduledRun(now time.Time) (runPlan, error) {
+	set, err := loadSchedules()
+	if err != nil {
+		return nil, err
+	}
+	items, err := loadItems()
+	if err != nil {
+		return nil, err
+	}
+	urls, err := loadUrls()
+	if err != nil {
+		return nil, err
+	}
+	last, err := loadPairRuns()
+	if err != nil {
+		return nil, err
+	}
+
+	plan := runPlan{}
+	var itemIDs, urlIDs []int64
+	for _, it := range items {
+		for _, u := range urls {
+			if !set.pair(it.ID, u.ID).due(last[pairKey{it.ID, u.ID}], now) {
+				continue
+			}
+			if plan[it.ID] == nil {
+				plan[it.ID] = map[int64]bool{}
+			}
+			plan[it.ID][u.ID] = true
+			itemIDs = append(itemIDs, it.ID)
+			urlIDs = append(urlIDs, u.ID)
+		}
+	}
+	if len(plan) == 0 {
+		return nil, nil
+	}
+
+	if _, err := db.Exec(`
+        INSERT INTO schedule_runs(item_id, url_id, last_run_at)
+        SELECT i, u, $3 FROM unnest($1::bigint[], $2::bigint[]) AS p(i, u)
+        ON CONFLICT (item_id, url_id) DO UPDATE SET last_run_at = EXCLUDED.last_run_at
+    `, pq.Int64Array(itemIDs), pq.Int64Array(urlIDs), now.UTC()); err != nil {
+		return nil, err
+	}
+	return plan, nil
+}
+
+// runScheduled starts a worker run for whatever is due. While a run is in
+// progress nothing is planned; due pairs are picked up on a later tick.
+// Without anything due it works off queued jobs whose retry time has come.
+func runScheduled(now time.Time) {
+	if workerRunning.Load() {
+		return
+	}
+	plan, err := planScheduledRun(now)
+	if err != nil {
+		log.Println("scheduler:", err)
+		return
+	}
+	if len(plan) == 0 {
+		resumeQueue()
+		return
+	}
+	pairs := 0
+	for _, sites := range plan {
+		pairs += len(sites)
+	}
+	log.Printf("Scheduled run: %d item(s), %d item/site pair(s) due\n", len(plan), pairs)
+	runWorker(runTriggerSchedule, plan)
+}
+
+// -------------------- API --------------------
+
+// scheduleNextRuns fills LastRunAt and NextRunAt of every schedule: the last
+// time and the next time any pair under it runs.
+func scheduleNextRuns(set *scheduleSet, items []Item, urls []URL, last map[pairKey]time.Time, now time.Time) {
+	earliest := func(cur *time.Time, t time.Time) *time.Time {
+		if t.IsZero() || (cur != nil && !t.Before(*cur)) {
+			return cur
+		}
+		return &t
+	}
+	latest := func(cur *time.Time, t time.Time) *time.Time {
+		if t.IsZero() || (cur != nil && !t.After(*cur)) {
+			return cur
+		}
+		return &t
+	}
+	for _, it := range items {
+		for _, u := range urls {
+			p := set.pair(it.ID, u.ID)
+			l := last[pairKey{it.ID, u.ID}]
+			next := p.next(l, now)
+			for _, s := range []*Schedule{p.item, p.site} {
+				if s != nil {
+					s.NextRunAt = earliest(s.NextRunAt, next)
+					s.LastRunAt = latest(s.LastRunAt, l)
+				}
+			}
+		}
+	}
+}
+
+type upcomingSite struct {
+	URLID     int64      `json:"url_id"`
+	Site      string     `json:"site"`
+	Cron      string     `json:"cron,omitempty"` // the site's schedule, if it has one
+	LastRunAt *time.Time `json:"last_run_at,omitempty"`
+	NextRunAt *time.Time `json:"next_run_at,omitempty"`
+}
+
+type upcomingItem struct {
+	ItemID    int64          `json:"item_id"`
+	Text      string         `json:"text"`
+	Cron      string         `json:"cron"` // effective item schedule
+	NextRunAt *time.Time     `json:"next_run_at,omitempty"`
+	Sites     []upcomingSite `json:"sites"`
+}
+
+func timePtr(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	return &t
+}
+
+// schedulesHandler serves GET /api/schedules (every schedule with its last
+// and next run; ?upcoming=true lists the next run of each item on each
+// site instead) and POST, which sets the override of an item or site (form
+// fields scope, item_id or url_id, cron, enabled).
+func schedulesHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		set, err := loadSchedules()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		items, err := loadItems()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		urls, err := loadUrls()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		last, err := loadPairRuns()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		now := time.Now()
+
+		if strings.ToLower(r.URL.Query().Get("upcoming")) != "true" {
+			scheduleNextRuns(set, items, urls, last, now)
+			out := make([]*Schedule, 0, len(set.all))
+			out = append(out, set.all...)
+			writeJSON(w, out)
+			return
+		}
+
+		out := make([]upcomingItem, 0, len(items))
+		for _, it := range items {
+			ui := upcomingItem{ItemID: it.ID, Text: it.Text, Cron: set.pair(it.ID, 0).item.Cron, Sites: []upcomingSite{}}
+			for _, u := range urls {
+				p := set.pair(it.ID, u.ID)
+				l := last[pairKey{it.ID, u.ID}]
+				next := p.next(l, now)
+				name := u.DisplayName
+				if name == "" {
+					name = u.URL
+				}
+				us := upcomingSite{URLID: u.ID, Site: name, LastRunAt: timePtr(l), NextRunAt: timePtr(next)}
+				if p.site != nil {
+					us.Cron = p.site.Cron
+				}
+				ui.Sites = append(ui.Sites, us)
+				if !next.IsZero() && (ui.NextRunAt == nil || next.Before(*ui.NextRunAt)) {
+					ui.NextRunAt = timePtr(next)
+				}
+			}
+			out = append(out, ui)
+		}
+		writeJSON(w, out)
+
+	case http.MethodPost:
+		if err := r.ParseForm(); err != nil {
+			http.Error(w, "invalid form", http.StatusBadRequest)
+			return
+		}
+		scope := strings.TrimSpace(r.FormValue("scope"))
+		expr := strings.TrimSpace(r.FormValue("cron"))
+		if _, err := parseCron(expr); err != nil {
+			http.Error(w, "invalid cron: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		enabled := strings.ToLower(r.FormValue("enabled")) != "false"
+
+		var column string
+		var target int64
+		var err error
+		switch scope {
+		case scheduleItem:
+			column = "item_id"
+			target, err = strconv.ParseInt(r.FormValue("item_id"), 10, 64)
+		case scheduleSite:
+			column = "url_id"
+			target, err = strconv.ParseInt(r.FormValue("url_id"), 10, 64)
+		default:
+			http.Error(w, "invalid scope (use item or site; edit the global schedule with PUT)", http.StatusBadRequest)
+			return
+		}
+		if err != nil || target <= 0 {
+			http.Error(w, "invalid "+column, http.StatusBadRequest)
+			return
+		}
+
+		var id int64
+		err = db.QueryRow(`
+            INSERT INTO schedules(scope, `+column+`, cron, enabled)
+            VALUES ($1, $2, $3, $4)
+            ON CONFLICT (`+column+`) WHERE scope = '`+scope+`'
+            DO UPDATE SET cron = EXCLUDED.cron, enabled = EXCLUDED.enabled, updated_at = CURRENT_TIMESTAMP
+            RETURNING id
+        `, scope, target, expr, enabled).Scan(&id)
+		if err != nil {
+			if strings.Contains(err.Error(), "foreign key") {
+				http.Error(w, column+" not found", http.StatusBadRequest)
+				return
+			}
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+		writeJSON(w, map[string]any{"id": id})
+
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// scheduleHandler serves PUT /api/schedules/{id} (form fields cron and/or
+// enabled) and DELETE, which removes an override; the global schedule can't
+// be deleted.
+func scheduleHandler(w http.ResponseWriter, r *http.Request) {
+	idStr := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/schedules/"), "/")
+	id, err := strconv.ParseInt(idStr, 10, 64)
+	if err != nil || id <= 0 {
+		http.Error(w, "invalid id", http.StatusBadRequest)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodPut:
+		if err := r.ParseForm(); err != nil {
+			http.Error(w, "invalid form", http.StatusBadRequest)
+			return
+		}
+		var expr sql.NullString
+		if _, ok := r.Form["cron"]; ok {
+			expr = sql.NullString{String: strings.TrimSpace(r.FormValue("cron")), Valid: true}
+			if _, err := parseCron(expr.String); err != nil {
+				http.Error(w, "invalid cron: "+err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+		var enabled sql.NullBool
+		if v := strings.ToLower(strings.TrimSpace(r.FormValue("enabled"))); v != "" {
+			enabled = sql.NullBool{Bool: v == "true", Valid: true}
+		}
+		res, err := db.Exec(`
+            UPDATE schedules
+            SET cron = COALESCE($1, cron), enabled = COALESCE($2, enabled), updated_at = CURRENT_TIMESTAMP
+            WHERE id = $3
+        `, expr, enabled, id)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			http.Error(w, "not found", http.StatusNotFound)
+			return
+		}
+		writeJSON(w, map[string]any{"ok": true})
+
+	case http.MethodDelete:
+		res, err := db.Exec(`DELETE FROM schedules WHERE id = $1 AND scope <> $2`, id, scheduleGlobal)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			http.Error(w, "not found (the global schedule can't be deleted)", http.StatusNotFound)
+			return
+		}
+		writeJSON(w, map[string]any{"ok": true})
+
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
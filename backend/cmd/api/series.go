@@ -0,0 +1,223 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+const (
+	itemTypeMovie  = "movie"
+	itemTypeSeries = "series"
+)
+
+// episodeKey identifies an episode of a series. Episode 0 means the whole
+// season (a season pack).
+type episodeKey struct {
+	Season  int `json:"season"`
+	Episode int `json:"episode"`
+}
+
+func (k episodeKey) String() string {
+	if k.Episode == 0 {
+		return fmt.Sprintf("S%02d", k.Season)
+	}
+	return fmt.Sprintf("S%02dE%02d", k.Season, k.Episode)
+}
+
+var episodeSpecEntry = regexp.MustCompile(`(?i)^S(\d{1,2})(?:E(\d{1,3})(?:\s*-\s*(?:S\d{1,2})?E?(\d{1,3}))?)?$`)
+
+// parseEpisodeSpec expands a comma-separated list of wanted episodes, e.g.
+// "S01, S02E01-E10, S03E05". A bare season ("S01") asks for a season pack.
+func parseEpisodeSpec(spec string) ([]episodeKey, error) {
+	var out []episodeKey
+	seen := map[episodeKey]bool{}
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		m := episodeSpecEntry.FindStringSubmatch(part)
+		if m == nil {
+			return nil, fmt.Errorf("invalid episode spec %q (use S01, S01E05 or S01E01-E10)", part)
+		}
+		season, _ := strconv.Atoi(m[1])
+		first, last := 0, 0
+		if m[2] != "" {
+			first, _ = strconv.Atoi(m[2])
+			last = first
+		}
+		if m[3] != "" {
+			last, _ = strconv.Atoi(m[3])
+		}
+		if last < first || last-first > 500 {
+			return nil, fmt.Errorf("invalid episode range %q", part)
+		}
+		for e := first; e <= last; e++ {
+			k := episodeKey{season, e}
+			if !seen[k] {
+				seen[k] = true
+				out = append(out, k)
+			}
+		}
+	}
+	return out, nil
+}
+
+// episodeKeyFor returns the episode (or season pack) a parsed release contains.
+func episodeKeyFor(info ReleaseInfo) (episodeKey, bool) {
+	if info.Season <= 0 {
+		return episodeKey{}, false
+	}
+	return episodeKey{info.Season, info.Episode}, true
+}
+
+// episodeWanted reports whether a release for key fills any missing episode.
+// Season packs satisfy a wanted season and every missing episode of it; single
+// episodes only satisfy themselves.
+func episodeWanted(missing map[episodeKey]bool, key episodeKey) bool {
+	if key.Episode != 0 {
+		return missing[key]
+	}
+	for k := range missing {
+		if k.Season == key.Season {
+			return true
+		}
+	}
+	return false
+}
+
+// episodeQuery builds the search text for the next missing episode.
+func episodeQuery(title string, k episodeKey) string {
+	return title + " " + k.String()
+}
+
+// -------------------- DB --------------------
+
+// syncWantedEpisodes replaces the item's wanted-but-not-found episodes with
+// the given list. Episodes that already have a match are kept.
+func syncWantedEpisodes(itemID int64, keys []episodeKey) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`DELETE FROM item_episodes WHERE item_id = $1 AND match_id IS NULL`, itemID); err != nil {
+		return err
+	}
+	for _, k := range keys {
+		if _, err := tx.Exec(`
+            INSERT INTO item_episodes(item_id, season, episode)
+            VALUES ($1, $2, $3)
+            ON CONFLICT (item_id, season, episode) DO NOTHING
+        `, itemID, k.Season, k.Episode); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// loadMissingEpisodes returns the item's wanted episodes that have no match
+// yet, in season/episode order.
+func loadMissingEpisodes(itemID int64) ([]episodeKey, error) {
+	rows, err := db.Query(`
+        SELECT season, episode
+        FROM item_episodes
+        WHERE item_id = $1 AND match_id IS NULL
+        ORDER BY season ASC, episode ASC
+    `, itemID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []episodeKey
+	for rows.Next() {
+		var k episodeKey
+		if err := rows.Scan(&k.Season, &k.Episode); err != nil {
+			return nil, err
+		}
+		out = append(out, k)
+	}
+	return out, rows.Err()
+}
+
+// recordEpisodeMatch links a match to the episodes it fills and returns them.
+// A season pack fills every missing episode of its season.
+func recordEpisodeMatch(itemID, matchID int64, key episodeKey) ([]episodeKey, error) {
+	if _, err := db.Exec(`UPDATE matches SET season = $1, episode = NULLIF($2, 0) WHERE id = $3`,
+		key.Season, key.Episode, matchID); err != nil {
+		return nil, err
+	}
+
+	var rows *sql.Rows
+	var err error
+	if key.Episode == 0 {
+		rows, err = db.Query(`
+            UPDATE item_episodes SET match_id = $1, found_at = CURRENT_TIMESTAMP
+            WHERE item_id = $2 AND season = $3 AND match_id IS NULL
+            RETURNING season, episode
+        `, matchID, itemID, key.Season)
+	} else {
+		rows, err = db.Query(`
+            UPDATE item_episodes SET match_id = $1, found_at = CURRENT_TIMESTAMP
+            WHERE item_id = $2 AND season = $3 AND episode = $4 AND match_id IS NULL
+            RETURNING season, episode
+        `, matchID, itemID, key.Season, key.Episode)
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var filled []episodeKey
+	for rows.Next() {
+		var k episodeKey
+		if err := rows.Scan(&k.Season, &k.Episode); err != nil {
+			return nil, err
+		}
+		filled = append(filled, k)
+	}
+	return filled, rows.Err()
+}
+
+// itemEpisodeStatus is the per-episode view returned by GET /api/items/{id}.
+type itemEpisodeStatus struct {
+	episodeKey
+	Code    string `json:"code"`
+	MatchID *int64 `json:"match_id,omitempty"`
+	FoundAt string `json:"found_at,omitempty"`
+}
+
+func loadItemEpisodes(itemID int64) (found, missing []itemEpisodeStatus, err error) {
+	rows, err := db.Query(`
+        SELECT season, episode, match_id, COALESCE(found_at::text, '')
+        FROM item_episodes
+        WHERE item_id = $1
+        ORDER BY season ASC, episode ASC
+    `, itemID)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+
+	found, missing = []itemEpisodeStatus{}, []itemEpisodeStatus{}
+	for rows.Next() {
+		var st itemEpisodeStatus
+		var matchID sql.NullInt64
+		if err := rows.Scan(&st.Season, &st.Episode, &matchID, &st.FoundAt); err != nil {
+			return nil, nil, err
+		}
+		st.Code = st.episodeKey.String()
+		if matchID.Valid {
+			st.MatchID = &matchID.Int64
+			found = append(found, st)
+		} else {
+			missing = append(missing, st)
+		}
+	}
+	return found, missing, rows.Err()
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"golang.org/x/text/runes"
+	"golang.org/x/text/transform"
+	"golang.org/x/text/unicode/norm"
+)
+
+// foldDiacritics decomposes text (NFKD) and drops the combining marks, so
+// "Amélie" becomes "Amelie" and full-width or ligature forms become ASCII.
+var foldDiacritics = transform.Chain(norm.NFKD, runes.Remove(runes.In(unicode.Mn)))
+
+// foldLetters covers Latin letters that have no decomposition.
+var foldLetters = strings.NewReplacer(
+	"ß", "ss", "æ", "ae", "œ", "oe", "ø", "o", "ł", "l", "đ", "d", "ð", "d", "þ", "th", "ı", "i",
+)
+
+// foldText lowercases s and folds diacritics. With MATCH_TRANSLITERATE=true
+// Cyrillic, Greek, Japanese kana and Korean Hangul are romanized as well.
+//
+// Chinese characters (and the kanji in Japanese titles) are not romanized:
+// that needs a pronunciation dictionary, and readings depend on context. They
+// are kept as they are, so a Chinese title only matches an item text or alias
+// written in the same characters, never a pinyin alias.
+func foldText(s string) string {
+	s = strings.ToLower(s)
+	translit := transliterationEnabled()
+	if translit {
+		// Kana and Hangul decompose under NFKD, so they go first
+		s = romanizeKanaHangul(s)
+	}
+	if folded, _, err := transform.String(foldDiacritics, s); err == nil {
+		s = folded
+	}
+	s = foldLetters.Replace(s)
+	if translit {
+		s = transliterateAlphabets(s)
+	}
+	return s
+}
+
+func transliterationEnabled() bool {
+	return strings.ToLower(os.Getenv("MATCH_TRANSLITERATE")) == "true"
+}
+
+// romanNumeral matches II..XXXIX. Single letters ("I", "V", "X") are left
+// alone since they are usually words or initials.
+var romanNumeral = regexp.MustCompile(`^x{0,3}(ix|iv|v?i{0,3})$`)
+
+var romanValues = map[byte]int{'i': 1, 'v': 5, 'x': 10}
+
+// romanToDigits converts a lowercase roman numeral token ("ii", "xiv") to its
+// decimal form, returning ok=false for anything else.
+func romanToDigits(tok string) (string, bool) {
+	if len(tok) < 2 || !romanNumeral.MatchString(tok) {
+		return "", false
+	}
+	total := 0
+	for i := 0; i < len(tok); i++ {
+		v := romanValues[tok[i]]
+		if i+1 < len(tok) && romanValues[tok[i+1]] > v {
+			total -= v
+		} else {
+			total += v
+		}
+	}
+	return strconv.Itoa(total), true
+}
+
+// canonicalTokens rewrites equivalent spellings to one form: "&" becomes
+// "and" and roman numerals become digits, so "Part II" equals "Part 2".
+func canonicalTokens(words []string) []string {
+	for i, w := range words {
+		if w == "&" {
+			words[i] = "and"
+		} else if d, ok := romanToDigits(w); ok {
+			words[i] = d
+		}
+	}
+	return words
+}
+
+// -------------------- Transliteration --------------------
+
+var alphabetRomanization = map[rune]string{
+	// Cyrillic
+	'а': "a", 'б': "b", 'в': "v", 'г': "g", 'д': "d", 'е': "e", 'ж': "zh", 'з': "z", 'и': "i",
+	'к': "k", 'л': "l", 'м': "m", 'н': "n", 'о': "o", 'п': "p", 'р': "r", 'с': "s", 'т': "t",
+	'у': "u", 'ф': "f", 'х': "kh", 'ц': "ts", 'ч': "ch", 'ш': "sh", 'щ': "shch", 'ъ': "", 'ы': "y",
+	'ь': "", 'э': "e", 'ю': "yu", 'я': "ya", 'і': "i", 'є': "ye", 'ґ': "g", 'ј': "j", 'љ': "lj",
+	'њ': "nj", 'ћ': "c", 'ђ': "dj", 'џ': "dz",
+	// Greek
+	'α': "a", 'β': "v", 'γ': "g", 'δ': "d", 'ε': "e", 'ζ': "z", 'η': "i", 'θ': "th", 'ι': "i",
+	'κ': "k", 'λ': "l", 'μ': "m", 'ν': "n", 'ξ': "x", 'ο': "o", 'π': "p", 'ρ': "r", 'σ': "s",
+	'ς': "s", 'τ': "t", 'υ': "y", 'φ': "f", 'χ': "ch", 'ψ': "ps", 'ω': "o",
+}
+
+// transliterateAlphabets romanizes Cyrillic and Greek letters. It runs after
+// diacritic folding, so "й" and "ё" arrive as "и" and "е".
+func transliterateAlphabets(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		if latin, ok := alphabetRomanization[r]; ok {
+			b.WriteString(latin)
+		} else {
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+// hiragana romanizes U+3041..U+3096 (Hepburn); katakana is mapped onto it.
+var hiragana = map[rune]string{
+	'あ': "a", 'い': "i", 'う': "u", 'え': "e", 'お': "o",
+	'か': "ka", 'き': "ki", 'く': "ku", 'け': "ke", 'こ': "ko",
+	'が': "ga", 'ぎ': "gi", 'ぐ': "gu", 'げ': "ge", 'ご': "go",
+	'さ': "sa", 'し': "shi", 'す': "su", 'せ': "se", 'そ': "so",
+	'ざ': "za", 'じ': "ji", 'ず': "zu", 'ぜ': "ze", 'ぞ': "zo",
+	'た': "ta", 'ち': "chi", 'つ': "tsu", 'て': "te", 'と': "to",
+	'だ': "da", 'ぢ': "ji", 'づ': "zu", 'で': "de", 'ど': "do",
+	'な': "na", 'に': "ni", 'ぬ': "nu", 'ね': "ne", 'の': "no",
+	'は': "ha", 'ひ': "hi", 'ふ': "fu", 'へ': "he", 'ほ': "ho",
+	'ば': "ba", 'び': "bi", 'ぶ': "bu", 'べ': "be", 'ぼ': "bo",
+	'ぱ': "pa", 'ぴ': "pi", 'ぷ': "pu", 'ぺ': "pe", 'ぽ': "po",
+	'ま': "ma", 'み': "mi", 'む': "mu", 'め': "me", 'も': "mo",
+	'や': "ya", 'ゆ': "yu", 'よ': "yo",
+	'ら': "ra", 'り': "ri", 'る': "ru", 'れ': "re", 'ろ': "ro",
+	'わ': "wa", 'ゐ': "i", 'ゑ': "e", 'を': "o", 'ん': "n", 'ゔ': "vu",
+	'ぁ': "a", 'ぃ': "i", 'ぅ': "u", 'ぇ': "e", 'ぉ': "o", 'ゎ': "wa", 'ゕ': "ka", 'ゖ': "ke",
+}
+
+var smallYoon = map[rune]string{'ゃ': "a", 'ゅ': "u", 'ょ': "o"}
+
+// Revised Romanization of Korean: initial, medial and final jamo of a syllable.
+var (
+	hangulInitials = []string{"g", "kk", "n", "d", "tt", "r", "m", "b", "pp", "s", "ss", "", "j", "jj", "ch", "k", "t", "p", "h"}
+	hangulMedials  = []string{"a", "ae", "ya", "yae", "eo", "e", "yeo", "ye", "o", "wa", "wae", "oe", "yo", "u", "wo", "we", "wi", "yu", "eu", "ui", "i"}
+	hangulFinals   = []string{"", "k", "k", "k", "n", "n", "n", "t", "l", "k", "m", "l", "l", "l", "p", "l", "m", "p", "p", "t", "t", "ng", "t", "t", "k", "t", "p", "t"}
+)
+
+func romanizeKanaHangul(s string) string {
+	rs := []rune(s)
+	var b strings.Builder
+	geminate := false // small tsu doubles the next consonant
+	for i := 0; i < len(rs); i++ {
+		r := rs[i]
+		if r >= 0x30A1 && r <= 0x30F6 {
+			r -= 0x60 // katakana -> hiragana
+		}
+		switch {
+		case r >= 0xAC00 && r <= 0xD7A3:
+			idx := int(r - 0xAC00)
+			b.WriteString(hangulInitials[idx/588] + hangulMedials[(idx%588)/28] + hangulFinals[idx%28])
+		case r == 'っ':
+			geminate = true
+		case r == 'ー':
+			// Long vowel mark; the vowel is already written once
+		case hiragana[r] != "":
+			roma := hiragana[r]
+			if i+1 < len(rs) {
+				next := rs[i+1]
+				if next >= 0x30A1 && next <= 0x30F6 {
+					next -= 0x60
+				}
+				if v, ok := smallYoon[next]; ok && strings.HasSuffix(roma, "i") && len(roma) > 1 {
+					// ki + ya -> kya, shi + ya -> sha
+					stem := strings.TrimSuffix(roma, "i")
+					if stem == "sh" || stem == "ch" || stem == "j" {
+						roma = stem + v
+					} else {
+						roma = stem + "y" + v
+					}
+					i++
+				}
+			}
+			if geminate {
+				if roma[0] == 'c' {
+					roma = "t" + roma
+				} else {
+					roma = roma[:1] + roma
+				}
+				geminate = false
+			}
+			b.WriteString(roma)
+		default:
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
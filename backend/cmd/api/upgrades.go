@@ -0,0 +1,106 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+var resolutionRanks = map[string]int{
+	"480p":  1,
+	"576p":  2,
+	"720p":  3,
+	"1080p": 4,
+	"1440p": 5,
+	"2160p": 6,
+}
+
+var sourceRanks = map[string]int{
+	"CAM":      1,
+	"TELESYNC": 2,
+	"TELECINE": 3,
+	"SCREENER": 4,
+	"DVD":      5,
+	"HDRip":    5,
+	"HDTV":     6,
+	"WEB":      7,
+	"WEBRip":   7,
+	"WEB-DL":   8,
+	"BluRay":   9,
+	"REMUX":    10,
+}
+
+// qualityRank orders releases by resolution first and source second, so a
+// 1080p WEBRip outranks a 720p BluRay. Unknown values rank lowest.
+func qualityRank(info ReleaseInfo) int {
+	return resolutionRanks[info.Resolution]*100 + sourceRanks[info.Source]
+}
+
+// resolutionRank returns the rank of a resolution such as "1080p", accepting
+// the same aliases as the release parser ("4K", "UHD").
+func resolutionRank(resolution string) int {
+	if r, ok := resolutionRanks[strings.ToLower(resolution)]; ok {
+		return r
+	}
+	return resolutionRanks[parseReleaseName("x "+resolution).Resolution]
+}
+
+func qualityLabel(info ReleaseInfo) string {
+	label := strings.TrimSpace(info.Resolution + " " + info.Source)
+	if label == "" {
+		return "unknown quality"
+	}
+	return label
+}
+
+// cutoffReached reports whether a release meets the item's cutoff resolution.
+func cutoffReached(cutoff string, info ReleaseInfo) bool {
+	c := resolutionRank(cutoff)
+	return c > 0 && resolutionRanks[info.Resolution] >= c
+}
+
+// bestExistingMatch returns the highest-ranked visible match for an item, if
+// any, by re-parsing the stored torrent titles.
+func bestExistingMatch(itemID int64) (ReleaseInfo, bool, error) {
+	rows, err := db.Query(`
+        SELECT COALESCE(torrent_text, matched_text, '')
+        FROM matches
+        WHERE item_id = $1 AND soft_delete = FALSE
+    `, itemID)
+	if err != nil {
+		return ReleaseInfo{}, false, err
+	}
+	defer rows.Close()
+
+	var best ReleaseInfo
+	found := false
+	for rows.Next() {
+		var title string
+		if err := rows.Scan(&title); err != nil {
+			return ReleaseInfo{}, false, err
+		}
+		info := parseReleaseName(title)
+		if !found || qualityRank(info) > qualityRank(best) {
+			best = info
+			found = true
+		}
+	}
+	return best, found, rows.Err()
+}
+
+func markItemSatisfied(itemID int64) error {
+	_, err := db.Exec(`UPDATE items SET satisfied = TRUE, updated_at = CURRENT_TIMESTAMP WHERE id = $1`, itemID)
+	return err
+}
+
+// maybeSendUpgradeSMS sends a distinct alert when a better release replaces
+// an earlier match.
+func maybeSendUpgradeSMS(itemText, fromQuality, toQuality, matchedTitle, matchedURL, site string) error {
+	if !twilioConfigured() {
+		return nil // not configured; do nothing
+	}
+	to := strings.TrimSpace(os.Getenv("ALERT_TO_NUMBER"))
+	msg := fmt.Sprintf("Upgrade found on %s\nItem: %s\n%s -> %s\nTitle: %s\n%s",
+		site, itemText, fromQuality, toQuality, matchedTitle, matchedURL)
+	return sendTwilioSMS(to, msg)
+}
@@ -9,6 +9,7 @@ require (
 	github.com/lithammer/fuzzysearch v1.1.8
 	github.com/playwright-community/playwright-go v0.4501.1
 	golang.org/x/crypto v0.19.0
+	golang.org/x/text v0.14.0
 )
 
 require (
@@ -17,5 +18,4 @@ require (
 	github.com/go-stack/stack v1.8.1 // indirect
 	go.uber.org/multierr v1.11.0 // indirect
 	golang.org/x/exp v0.0.0-20240506185415-9bf2ced13842 // indirect
-	golang.org/x/text v0.14.0 // indirect
 )
@@ -5,6 +5,7 @@ type URL = { id: number; url: string; display_name?: string; config?: string };
 type Match = { id: number; item: string; url: string; site: string; torrent_text?: string; magnet_link?: string; file_size?: string; seeds?: string; leechers?: string; created: string };
 type Log = { id: number; timestamp: string; description: string; success: boolean };
 type LogsResponse = { logs: Log[]; page: number; page_size: number; total: number; total_pages: number };
+type CatalogTitle = { id: string; type: string; title: string; year?: number };
 
 interface AppProps {
   token: string;
@@ -161,15 +162,41 @@ export default function App({ token, username, onLogout }: AppProps) {
     };
   }, []);
 
+  // Asks which catalog title an ambiguous item means. Returns the form field
+  // to resend with (catalog_id, or catalog=skip to keep the text as typed),
+  // or null if the user cancelled.
+  function pickCatalogTitle(v: string, suggestions: CatalogTitle[]): Record<string, string> | null {
+    const list = suggestions
+      .map((s, i) => `${i + 1}. ${s.title}${s.year ? ` (${s.year})` : ""} [${s.type}]`)
+      .join("\n");
+    const answer = prompt(`"${v}" matches several titles:\n${list}\n\nEnter a number, or leave empty to add the text as typed.`, "1");
+    if (answer === null) return null;
+    const n = parseInt(answer.trim(), 10);
+    if (n >= 1 && n <= suggestions.length) {
+      return { catalog_id: suggestions[n - 1].id };
+    }
+    return { catalog: "skip" };
+  }
+
   async function add() {
     const v = text.trim();
     if (!v) return;
     try {
       console.log("Adding item:", v);
-      const res = await authFetch("/api/items", {
+      let res = await authFetch("/api/items", {
         method: "POST",
         body: new URLSearchParams({ text: v }),
       });
+      if (res.status === 300) {
+        // Ambiguous title in the catalog - let the user pick one
+        const data = await res.json();
+        const choice = pickCatalogTitle(v, data.suggestions || []);
+        if (!choice) return;
+        res = await authFetch("/api/items", {
+          method: "POST",
+          body: new URLSearchParams({ text: v, ...choice }),
+        });
+      }
       if (!res.ok) {
         if (res.status === 409) {
           // Conflict - item already exists